@@ -1,33 +1,84 @@
 package cdc
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"net/http"
+	"net/textproto"
 	"os"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // ErrNotFound is returned if the entry is not found.
 var ErrNotFound = errors.New("entry not found")
 
+// ErrInvalidAddr is returned if an address is not well formed
+// or does not point to the expected kind of record.
+var ErrInvalidAddr = errors.New("invalid address")
+
+// ErrMalformed is returned if a size or an offset read from the cache
+// is out of range.
+var ErrMalformed = errors.New("malformed data")
+
+// ErrInconsistent is returned in snapshot mode if an entry fails its
+// self hash or is marked dirty, as the browser was writing it.
+var ErrInconsistent = errors.New("inconsistent entry")
+
+// ErrUnsupportedEncoding is returned if a body is encoded
+// with an unsupported content encoding, like "br".
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// EntryError records an error and the address of the entry that caused it.
+type EntryError struct {
+	Op   string // "open", "header", "body", "stream" or "rankings"
+	Addr Addr
+	Err  error
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("%s entry %d: %v", e.Op, e.Addr, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
+
 // Entry represents a HTTP response as stored in the cache.
 // An Entry is stored in one of the "data_[0-9]" files or in a "f_[0-9]+" separate file.
 type Entry struct {
 	*entryStore
-	dir string
+	addr Addr
+	dir  string
+	r    addrReader
+	key  string // key longer than the entry store, if read
 }
 
 // OpenEntry returns the Entry at the specified address.
+// The returned error is of type *EntryError.
 func OpenEntry(addr Addr, dir string) (*Entry, error) {
-	b, err := readAddr(addr, dir)
+	return openEntry(addr, dir, dirReader(dir))
+}
+
+// openEntry returns the Entry at the specified address, read with r.
+func openEntry(addr Addr, dir string, r addrReader) (*Entry, error) {
+	if !addr.sanityCheckForEntry() {
+		return nil, &EntryError{Op: "open", Addr: addr, Err: ErrInvalidAddr}
+	}
+
+	b, err := readAddr(addr, r)
 	if err != nil {
-		return nil, fmt.Errorf("open entry: %d, %v", addr, err)
+		return nil, &EntryError{Op: "open", Addr: addr, Err: err}
 	}
 
 	reader := bytes.NewReader(b)
@@ -35,141 +86,435 @@ func OpenEntry(addr Addr, dir string) (*Entry, error) {
 
 	err = binary.Read(reader, binary.LittleEndian, &block)
 	if err != nil {
-		return nil, fmt.Errorf("read entry: %d, %v", addr, err)
+		return nil, &EntryError{Op: "open", Addr: addr, Err: err}
 	}
 
-	entry := Entry{entryStore: &block, dir: dir}
+	entry := Entry{entryStore: &block, addr: addr, dir: dir, r: r}
+	if block.KeyLen > blockKeyLen {
+		// the URL is trimmed if the key can not be read
+		entry.key, _ = readLongKey(&block, b, r)
+	}
 	return &entry, nil
 }
 
+// readLongKey reads the key longer than the entry store: in the next
+// blocks of the entry b, or at the LongKey address, as Chromium does
+// for the keys longer than maxInternalKeyLen.
+func readLongKey(store *entryStore, b []byte, r addrReader) (string, error) {
+	if !store.LongKey.initialized() {
+		start := entryStoreSize - int(blockKeyLen)
+		if end := start + int(store.KeyLen); end <= len(b) {
+			return string(b[start:end]), nil
+		}
+		return "", fmt.Errorf("key length %d exceeds entry: %w", store.KeyLen, ErrMalformed)
+	}
+	key, err := r.readAddrSize(store.LongKey, store.KeyLen)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// truncatedKey reports whether the key is longer than the entry store,
+// and could not be read.
+func (e *Entry) truncatedKey() bool {
+	return e.KeyLen > blockKeyLen && e.key == ""
+}
+
+// Addr returns the entry address.
+func (e *Entry) Addr() Addr {
+	return e.addr
+}
+
+// Created returns the creation time of the entry.
+func (e *Entry) Created() time.Time {
+	return chromeTime(e.CreationTime)
+}
+
 // URL returns the entry URL.
 func (e *Entry) URL() string {
+	if e.key != "" {
+		return e.key
+	}
 	var key []byte
-	if e.LongKey == 0 {
+	if e.LongKey == 0 && e.KeyLen >= 0 {
 		if e.KeyLen <= blockKeyLen {
 			key = e.Key[0:e.KeyLen]
 		} else {
-			// KeyLen may be larger, return trimmed
+			// the long key could not be read, return trimmed
 			key = e.Key[:]
 		}
 	}
 	return string(key)
 }
 
-// Header returns the HTTP header.
-func (e *Entry) Header() (http.Header, error) {
-	var (
-		// offset = sizeof(
-		// 		infoSize     int32
-		// 		flag         int32
-		// 		requestTime  int64
-		// 		responseTime int64
-		// )
-		offset     int64 = 24
-		headerSize int32
-	)
+// Rankings holds the LRU info of an entry.
+type Rankings struct {
+	LastUsed     time.Time
+	LastModified time.Time
+	Dirty        int32 // Non zero if the entry was being modified.
+}
+
+// Rankings returns the LRU info of the entry, read from its rankings node.
+// The returned error is of type *EntryError.
+func (e *Entry) Rankings() (*Rankings, error) {
+	node, err := e.rankingsNode()
+	if err != nil {
+		return nil, err
+	}
+
+	rankings := Rankings{
+		LastUsed:     chromeTime(node.LastUsed),
+		LastModified: chromeTime(node.LastModified),
+		Dirty:        node.Dirty,
+	}
+	return &rankings, nil
+}
+
+// rankingsNode reads the rankings node of the entry.
+func (e *Entry) rankingsNode() (*rankingsNode, error) {
+	addr := e.RankingsNode
+	if !addr.initialized() || addr.fileType() != 1 { // RANKINGS
+		return nil, &EntryError{Op: "rankings", Addr: e.addr, Err: ErrInvalidAddr}
+	}
+
+	b, err := e.r.readAddrSize(addr, int32(addr.blockSize()))
+	if err != nil {
+		return nil, &EntryError{Op: "rankings", Addr: e.addr, Err: err}
+	}
+
+	var node rankingsNode
+	err = binary.Read(bytes.NewReader(b), binary.LittleEndian, &node)
+	if err != nil {
+		return nil, &EntryError{Op: "rankings", Addr: e.addr, Err: err}
+	}
+	if node.Contents != e.addr {
+		err = fmt.Errorf("rankings node of entry %d: %w", node.Contents, ErrMalformed)
+		return nil, &EntryError{Op: "rankings", Addr: e.addr, Err: err}
+	}
+	return &node, nil
+}
+
+// ResponseInfo holds the HTTP response info stored with an entry.
+type ResponseInfo struct {
+	Flags        int32     // Combination of the response info flags.
+	RequestTime  time.Time // Time the request was issued.
+	ResponseTime time.Time // Time the response was received.
+	StatusLine   string    // First line of the headers, like "HTTP/1.1 200 OK".
+	StatusCode   int       // Zero if it could not be parsed.
+	Header       http.Header
+	HeaderFields []HeaderField // Header fields in stored order, with all their values.
+}
+
+// HeaderField is a header line of a response, its name canonicalized
+// as the keys of http.Header.
+type HeaderField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
 
+// ResponseInfo returns the HTTP response info.
+// The returned error is of type *EntryError.
+func (e *Entry) ResponseInfo() (*ResponseInfo, error) {
 	size, addr := e.DataSize[0], e.DataAddr[0]
-	b, err := readAddrSize(addr, e.dir, uint32(size))
+	b, err := e.r.readAddrSize(addr, size)
 	if err != nil {
-		return nil, fmt.Errorf("read header: %v", err)
+		return nil, &EntryError{Op: "header", Addr: e.addr, Err: err}
 	}
-	reader := bytes.NewReader(b)
+	info, err := parseResponseInfo(b)
+	if err != nil {
+		return nil, &EntryError{Op: "header", Addr: e.addr, Err: err}
+	}
+	return info, nil
+}
 
-	_, err = reader.Seek(offset, io.SeekStart)
+// Header returns the HTTP header.
+// The returned error is of type *EntryError.
+func (e *Entry) Header() (http.Header, error) {
+	info, err := e.ResponseInfo()
 	if err != nil {
-		return nil, fmt.Errorf("seek header: %v", err)
+		return nil, err
+	}
+	return info.Header, nil
+}
+
+// parseResponseInfo parses the response info stream.
+func parseResponseInfo(b []byte) (*ResponseInfo, error) {
+	var pickle struct {
+		PayloadSize  uint32
+		Flags        int32
+		RequestTime  int64
+		ResponseTime int64
+		HeaderSize   int32
 	}
 
-	err = binary.Read(reader, binary.LittleEndian, &headerSize)
+	reader := bytes.NewReader(b)
+	err := binary.Read(reader, binary.LittleEndian, &pickle)
 	if err != nil {
-		return nil, fmt.Errorf("read header size: %v", err)
+		return nil, fmt.Errorf("read response info: %w", ErrMalformed)
+	}
+
+	headerSize := pickle.HeaderSize
+	if headerSize < 0 || int64(headerSize) > int64(reader.Len()) {
+		return nil, fmt.Errorf("read header size: %d out of range: %w",
+			headerSize, ErrMalformed)
 	}
 
 	p := make([]byte, headerSize)
-	err = binary.Read(reader, binary.LittleEndian, p)
+	_, err = io.ReadFull(reader, p)
 	if err != nil {
-		return nil, fmt.Errorf("read header data: %v", err)
+		return nil, fmt.Errorf("read header data: %w", err)
+	}
+
+	info := ResponseInfo{
+		Flags:        pickle.Flags,
+		RequestTime:  chromeTime(uint64(pickle.RequestTime)),
+		ResponseTime: chromeTime(uint64(pickle.ResponseTime)),
+		Header:       make(http.Header),
 	}
 
-	header := make(http.Header)
 	lines := bytes.Split(p, []byte{0})
+	if bytes.HasPrefix(lines[0], []byte("HTTP/")) {
+		info.StatusLine = string(lines[0])
+		lines = lines[1:]
+	}
 
 	for _, line := range lines {
 		kv := bytes.SplitN(line, []byte{':'}, 2)
 		if len(kv) == 2 {
-			header.Add(
-				string(bytes.TrimSpace(kv[0])),
-				string(bytes.TrimSpace(kv[1])))
+			field := HeaderField{
+				Name:  textproto.CanonicalMIMEHeaderKey(string(bytes.TrimSpace(kv[0]))),
+				Value: string(bytes.TrimSpace(kv[1])),
+			}
+			info.Header.Add(field.Name, field.Value)
+			info.HeaderFields = append(info.HeaderFields, field)
 		}
 	}
-	return header, nil
+
+	// "HTTP/1.1 200 OK" or the "status:200" pseudo-header
+	status := info.Header.Get("Status")
+	if fields := strings.Fields(info.StatusLine); len(fields) > 1 {
+		status = fields[1]
+	}
+	if code, err := strconv.Atoi(status); err == nil {
+		info.StatusCode = code
+	}
+	return &info, nil
+}
+
+// Body returns the HTTP body, as stored.
+// The returned error is of type *EntryError.
+func (e *Entry) Body() (io.ReadSeekCloser, error) {
+	return e.openStream("body", 1)
+}
+
+// Stream returns the raw data of the stream index, from 0 to 3.
+// Stream 0 holds the response info, stream 1 the body,
+// and stream 2 the metadata of the renderer, if any.
+// An empty stream is returned if the stream is not stored.
+// The returned error is of type *EntryError.
+func (e *Entry) Stream(index int) (io.ReadSeekCloser, error) {
+	if index < 0 || index >= len(e.DataAddr) {
+		err := fmt.Errorf("stream %d out of range: %w", index, ErrInvalidAddr)
+		return nil, &EntryError{Op: "stream", Addr: e.addr, Err: err}
+	}
+	if e.DataSize[index] == 0 && e.DataAddr[index] == 0 {
+		return nopSeekCloser{bytes.NewReader(nil)}, nil
+	}
+	return e.openStream("stream", index)
 }
 
-// Body returns the HTTP body.
-func (e *Entry) Body() (io.ReadCloser, error) {
-	size, addr := e.DataSize[1], e.DataAddr[1]
-	if !addr.initialized() {
-		return nil, fmt.Errorf("open body: invalid address")
+// openStream returns the data of the stream index,
+// errors are reported with op.
+func (e *Entry) openStream(op string, index int) (io.ReadSeekCloser, error) {
+	size, addr := e.DataSize[index], e.DataAddr[index]
+	if !addr.initialized() || !addr.sanityCheck() {
+		return nil, &EntryError{Op: op, Addr: e.addr, Err: ErrInvalidAddr}
+	}
+	if size < 0 {
+		err := fmt.Errorf("size %d out of range: %w", size, ErrMalformed)
+		return nil, &EntryError{Op: op, Addr: e.addr, Err: err}
 	}
 
 	if addr.separateFile() {
-		name := path.Join(e.dir, addr.fileName())
-		file, err := os.Open(name)
+		file, err := e.r.openFile(addr)
 		if err != nil {
-			return nil, fmt.Errorf("open body: %v", err)
+			return nil, &EntryError{Op: op, Addr: e.addr, Err: err}
 		}
-		return file, nil
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return nil, &EntryError{Op: op, Addr: e.addr, Err: err}
+		}
+		if info.Size() < int64(size) {
+			_ = file.Close()
+			err = fmt.Errorf("size %d exceeds file size %d: %w",
+				size, info.Size(), ErrMalformed)
+			return nil, &EntryError{Op: op, Addr: e.addr, Err: err}
+		}
+		section := io.NewSectionReader(file, 0, int64(size))
+		return &sectionReadCloser{SectionReader: section, Closer: file}, nil
 	}
 
-	b, err := readAddrSize(addr, e.dir, uint32(size))
+	b, err := e.r.readAddrSize(addr, size)
 	if err != nil {
-		return nil, fmt.Errorf("read body: %v", err)
+		return nil, &EntryError{Op: op, Addr: e.addr, Err: err}
 	}
-	reader := bytes.NewReader(b)
-	return ioutil.NopCloser(reader), nil
+	return nopSeekCloser{bytes.NewReader(b)}, nil
 }
 
-func readAddr(addr Addr, dir string) ([]byte, error) {
-	if !addr.initialized() {
-		return nil, fmt.Errorf("readAddr: invalid address")
+// DecodedBody returns the HTTP body, decoded according to the
+// Content-Encoding header. The supported encodings are gzip and deflate,
+// other encodings fail with ErrUnsupportedEncoding.
+func (e *Entry) DecodedBody() (io.ReadCloser, error) {
+	header, err := e.Header()
+	if err != nil {
+		return nil, err
+	}
+	body, err := e.Body()
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := decodeBody(body, header.Get("Content-Encoding"))
+	if err != nil {
+		_ = body.Close()
+		return nil, &EntryError{Op: "body", Addr: e.addr, Err: err}
+	}
+	return decoded, nil
+}
+
+// decodeBody returns body decoded from the list of encodings,
+// in the order they were applied.
+func decodeBody(body io.ReadCloser, encodings string) (io.ReadCloser, error) {
+	decoded := decodedBody{Reader: body, closers: []io.Closer{body}}
+
+	list := strings.Split(encodings, ",")
+	for i := len(list) - 1; i >= 0; i-- {
+		switch encoding := strings.ToLower(strings.TrimSpace(list[i])); encoding {
+		case "", "identity":
+
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(decoded.Reader)
+			if err != nil {
+				return nil, fmt.Errorf("decode %s: %w", encoding, err)
+			}
+			decoded.Reader = zr
+			decoded.closers = append(decoded.closers, zr)
+
+		case "deflate":
+			// deflate should be zlib, but some servers send raw deflate
+			br := bufio.NewReader(decoded.Reader)
+			var zr io.ReadCloser
+			if b, err := br.Peek(2); err == nil && b[0]&0x0f == 8 &&
+				(uint16(b[0])<<8|uint16(b[1]))%31 == 0 {
+				zr, err = zlib.NewReader(br)
+				if err != nil {
+					return nil, fmt.Errorf("decode %s: %w", encoding, err)
+				}
+			} else {
+				zr = flate.NewReader(br)
+			}
+			decoded.Reader = zr
+			decoded.closers = append(decoded.closers, zr)
+
+		default:
+			return nil, fmt.Errorf("decode %s: %w", encoding, ErrUnsupportedEncoding)
+		}
+	}
+	return &decoded, nil
+}
+
+// decodedBody reads a decoded body and closes the decoders and the body.
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decodedBody) Close() error {
+	var err error
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		if e := d.closers[i].Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// nopSeekCloser is a ReadSeeker with a no-op Close method.
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+// sectionReadCloser reads a section of a file and closes the file.
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+func readAddr(addr Addr, r addrReader) ([]byte, error) {
+	if !addr.initialized() || addr.separateFile() {
+		return nil, fmt.Errorf("readAddr: %w", ErrInvalidAddr)
 	}
 	size := addr.blockSize() * addr.numBlocks()
-	return readAddrSize(addr, dir, size)
+	return r.readAddrSize(addr, int32(size))
 }
 
-func readAddrSize(addr Addr, dir string, size uint32) ([]byte, error) {
-	if !addr.initialized() {
-		return nil, fmt.Errorf("readAddr: invalid address")
+// readAddrSize reads size bytes at addr, opening the file in dir.
+func readAddrSize(addr Addr, dir string, size int32) ([]byte, error) {
+	err := checkAddrSize(addr, size)
+	if err != nil {
+		return nil, err
 	}
 
 	name := path.Join(dir, addr.fileName())
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("readAddr: %w", err)
+	}
+	defer file.Close()
+
 	if addr.separateFile() {
-		data, err := ioutil.ReadFile(name)
+		info, err := file.Stat()
 		if err != nil {
-			return nil, fmt.Errorf("readAddr: %v", err)
+			return nil, fmt.Errorf("readAddr: %w", err)
 		}
-		return data, nil
+		if int64(size) > info.Size() {
+			return nil, fmt.Errorf("readAddr: size %d exceeds file size %d: %w",
+				size, info.Size(), ErrMalformed)
+		}
+		return readAt(file, 0, size)
 	}
+	return readAt(file, addr.blockOffset(), size)
+}
 
-	file, err := os.Open(name)
-	if err != nil {
-		return nil, fmt.Errorf("readAddr: %v", err)
+// checkAddrSize checks that size bytes may be read at addr.
+func checkAddrSize(addr Addr, size int32) error {
+	if !addr.initialized() || !addr.sanityCheck() {
+		return fmt.Errorf("readAddr: %w", ErrInvalidAddr)
 	}
-	defer close(file)
+	if size < 0 {
+		return fmt.Errorf("readAddr: size %d out of range: %w", size, ErrMalformed)
+	}
+	if addr.separateFile() {
+		return nil
+	}
+	if capacity := addr.blockSize() * addr.numBlocks(); uint32(size) > capacity {
+		return fmt.Errorf("readAddr: size %d exceeds block capacity %d: %w",
+			size, capacity, ErrMalformed)
+	}
+	return nil
+}
 
-	offset := addr.startBlock()*addr.blockSize() + uint32(blockHeaderSize)
+func readAt(r io.ReaderAt, offset int64, size int32) ([]byte, error) {
 	block := make([]byte, size)
-
-	_, err = file.ReadAt(block, int64(offset))
+	_, err := r.ReadAt(block, offset)
 	if err != nil {
-		return nil, fmt.Errorf("readAddr: %v", err)
+		return nil, fmt.Errorf("readAddr: %w", err)
 	}
 	return block, nil
 }
-
-func close(f *os.File) {
-	if err := f.Close(); err != nil {
-		log.Printf("Error closing file %s: %v\n", f.Name(), err)
-	}
-}
@@ -0,0 +1,210 @@
+package cdc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+)
+
+// ErrClosed is returned when reading from a closed Cache.
+var ErrClosed = errors.New("cache closed")
+
+// addrReader reads the data stored at an address.
+type addrReader interface {
+	readAddrSize(addr Addr, size int32) ([]byte, error)
+	// openFile opens the separate file of addr.
+	openFile(addr Addr) (*os.File, error)
+}
+
+// dirReader reads the data stored at an address,
+// opening and closing the file for each read.
+type dirReader string
+
+func (dir dirReader) readAddrSize(addr Addr, size int32) ([]byte, error) {
+	return readAddrSize(addr, string(dir), size)
+}
+
+func (dir dirReader) openFile(addr Addr) (*os.File, error) {
+	return os.Open(path.Join(string(dir), addr.fileName()))
+}
+
+// blockFiles reads the data stored at an address,
+// keeping the block files open between reads.
+// The separate files are still opened for each read.
+//
+// It is safe for concurrent use by multiple goroutines.
+type blockFiles struct {
+	dir      string
+	separate string // directory of the separate files
+	mmap     bool   // memory-map the block files
+	mu       sync.RWMutex
+	files    map[uint32]*blockFile // [file number]data_N
+	closed   bool
+}
+
+func newBlockFiles(dir string, mmap bool) *blockFiles {
+	return &blockFiles{
+		dir:      dir,
+		separate: dir,
+		mmap:     mmap,
+		files:    make(map[uint32]*blockFile),
+	}
+}
+
+func (b *blockFiles) readAddrSize(addr Addr, size int32) ([]byte, error) {
+	err := checkAddrSize(addr, size)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return nil, fmt.Errorf("readAddr: %w", ErrClosed)
+	}
+	if addr.separateFile() {
+		return readAddrSize(addr, b.separate, size)
+	}
+
+	// the file is used only as found in b.files under the read lock,
+	// a refresh may close the file opened while the lock was released
+	for {
+		if b.closed {
+			return nil, fmt.Errorf("readAddr: %w", ErrClosed)
+		}
+		if file, ok := b.files[addr.fileNumber()]; ok {
+			return readAt(file, addr.blockOffset(), size)
+		}
+
+		b.mu.RUnlock()
+		_, err = b.open(addr)
+		b.mu.RLock()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (b *blockFiles) openFile(addr Addr) (*os.File, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+	return os.Open(path.Join(b.separate, addr.fileName()))
+}
+
+// open opens the block file of addr, if not already open.
+func (b *blockFiles) open(addr Addr) (*blockFile, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, fmt.Errorf("readAddr: %w", ErrClosed)
+	}
+	number := addr.fileNumber()
+	if file, ok := b.files[number]; ok {
+		return file, nil
+	}
+
+	file, err := os.Open(path.Join(b.dir, addr.fileName()))
+	if err != nil {
+		return nil, fmt.Errorf("readAddr: %w", err)
+	}
+
+	block := blockFile{file: file}
+	if b.mmap {
+		block.data, err = mmap(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("readAddr: mmap %s: %w", file.Name(), err)
+		}
+	}
+	b.files[number] = &block
+	return &block, nil
+}
+
+// close closes all the block files.
+// Reads fail with ErrClosed afterwards.
+func (b *blockFiles) close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var err error
+	for number, file := range b.files {
+		if e := file.close(); e != nil && err == nil {
+			err = e
+		}
+		delete(b.files, number)
+	}
+	b.closed = true
+	return err
+}
+
+// refresh closes the block files which were replaced or, if mapped,
+// which were resized since they were opened. They are opened again
+// by the next reads.
+func (b *blockFiles) refresh() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var err error
+	for number, file := range b.files {
+		opened, e := file.file.Stat()
+		if e != nil {
+			err = e
+			continue
+		}
+		current, e := os.Stat(file.file.Name())
+		if e == nil && os.SameFile(opened, current) &&
+			(file.data == nil || int64(len(file.data)) == current.Size()) {
+			continue
+		}
+		if e := file.close(); e != nil && err == nil {
+			err = e
+		}
+		delete(b.files, number)
+	}
+	return err
+}
+
+// blockFile is an open block file, optionally memory-mapped.
+type blockFile struct {
+	file *os.File
+	data []byte // mapped content, nil if not mapped
+}
+
+// ReadAt implements the io.ReaderAt interface.
+func (f *blockFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.data == nil {
+		return f.file.ReadAt(p, off)
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset: %d", off)
+	}
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *blockFile) close() error {
+	var err error
+	if f.data != nil {
+		err = munmap(f.data)
+		f.data = nil
+	}
+	if e := f.file.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
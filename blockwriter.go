@@ -0,0 +1,748 @@
+package cdc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"time"
+)
+
+// nibbleFree is the number of free blocks at the end of a nibble of an
+// allocation map, where an allocation of up to 4 blocks starts.
+var nibbleFree = [16]int{4, 3, 2, 2, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0}
+
+// blockWriter writes entries into a blockfile cache.
+//
+// The entries and their data are written as they are added, the headers
+// of the index and of the block files are written on Close.
+type blockWriter struct {
+	dir   string
+	index indexHeader
+	table []Addr
+	files [4]*writeFile // data_0 to data_3
+}
+
+// writeFile is a block file open for writing.
+type writeFile struct {
+	file   *os.File
+	header blockFileHeader
+}
+
+// newBlockWriter returns a writer of the blockfile cache of dir,
+// creating the cache if dir does not hold one.
+func newBlockWriter(dir string) (*blockWriter, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("new writer: %w", err)
+	}
+
+	w := blockWriter{dir: dir}
+	index, table, err := readIndex(dir)
+	switch {
+	case err == nil:
+		w.index, w.table = *index, table
+	case errors.Is(err, fs.ErrNotExist):
+		w.index = indexHeader{
+			Magic:      magicNumber,
+			Version:    indexVersion,
+			TableLen:   indexTableSize,
+			CreateTime: chromeTimestamp(time.Now()),
+		}
+		w.table = make([]Addr, indexTableSize)
+	default:
+		return nil, fmt.Errorf("new writer: %w", err)
+	}
+
+	for number := range w.files {
+		w.files[number], err = openWriteFile(dir, number)
+		if err != nil {
+			w.closeFiles()
+			return nil, fmt.Errorf("new writer: %w", err)
+		}
+	}
+	return &w, nil
+}
+
+// openWriteFile opens the block file data_N of dir, creating it if needed.
+func openWriteFile(dir string, number int) (*writeFile, error) {
+	file, err := os.OpenFile(path.Join(dir, fmt.Sprintf("data_%d", number)), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	f := writeFile{file: file}
+	if info.Size() == 0 {
+		f.header = blockFileHeader{
+			Magic:     blockMagic,
+			Version:   blockVersion,
+			ThisFile:  int16(number),
+			EntrySize: int32(blockAddr(number, 0, 1).blockSize()),
+		}
+		return &f, nil
+	}
+
+	err = binary.Read(file, binary.LittleEndian, &f.header)
+	if err == nil && f.header.Magic != blockMagic {
+		err = fmt.Errorf("%s magic: %x, want: %x: %w",
+			file.Name(), f.header.Magic, blockMagic, ErrMalformed)
+	}
+	if err == nil && f.header.NextFile != 0 {
+		err = fmt.Errorf("%s: chained block files not supported", file.Name())
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &f, nil
+}
+
+// blockAddr returns the address of n blocks from block in data_N.
+func blockAddr(number, block, n int) Addr {
+	fileType := uint32(number + 1) // RANKINGS, BLOCK_256, BLOCK_1K, BLOCK_4K
+	return Addr(initializedMask | fileType<<fileTypeOffset |
+		uint32(n-1)<<numBlocksOffset | uint32(number)<<fileSelectorOffset | uint32(block))
+}
+
+// alloc marks n consecutive blocks as used, within a nibble of the
+// allocation map, and returns the first one. It reports false if
+// the file is full.
+func (f *writeFile) alloc(n int) (int, bool) {
+	for i := range int(f.header.MaxEntries / 4) {
+		nibble := f.header.AllocationMap[i/8] >> (4 * (i % 8)) & 0xf
+		free := nibbleFree[nibble]
+		if free < n {
+			continue
+		}
+
+		start := i*4 + 4 - free
+		for b := start; b < start+n; b++ {
+			f.header.AllocationMap[b/32] |= 1 << (b % 32)
+		}
+		f.header.NumEntries++
+		f.countEmpty()
+		return start, true
+	}
+	return 0, false
+}
+
+// free marks the blocks of addr as unused.
+func (f *writeFile) free(addr Addr) {
+	start := int(addr.startBlock())
+	for b := start; b < start+int(addr.numBlocks()); b++ {
+		f.header.AllocationMap[b/32] &^= 1 << (b % 32)
+	}
+	f.header.NumEntries--
+	f.countEmpty()
+}
+
+// countEmpty counts the nibbles of the allocation map by free blocks.
+func (f *writeFile) countEmpty() {
+	f.header.Empty = countEmpty(&f.header.AllocationMap, f.header.MaxEntries)
+}
+
+// countEmpty counts the nibbles of the allocation map of n blocks
+// by free blocks at their end.
+func countEmpty(allocationMap *[maxBlocks / 32]uint32, n int32) [4]int32 {
+	var empty [4]int32
+	for i := range int(n / 4) {
+		nibble := allocationMap[i/8] >> (4 * (i % 8)) & 0xf
+		if free := nibbleFree[nibble]; free > 0 {
+			empty[free-1]++
+		}
+	}
+	return empty
+}
+
+// grow adds blockGrowth blocks to the file.
+func (f *writeFile) grow() error {
+	if int(f.header.MaxEntries+blockGrowth) > maxBlocks {
+		return fmt.Errorf("%s: block file full", f.file.Name())
+	}
+	f.header.MaxEntries += blockGrowth
+	f.countEmpty()
+	size := int64(blockHeaderSize) + int64(f.header.MaxEntries)*int64(f.header.EntrySize)
+	return f.file.Truncate(size)
+}
+
+// allocate allocates n blocks in data_N.
+func (w *blockWriter) allocate(number, n int) (Addr, error) {
+	f := w.files[number]
+	block, ok := f.alloc(n)
+	if !ok {
+		err := f.grow()
+		if err != nil {
+			return 0, err
+		}
+		block, _ = f.alloc(n)
+	}
+	return blockAddr(number, block, n), nil
+}
+
+func (w *blockWriter) readAddrSize(addr Addr, size int32) ([]byte, error) {
+	err := checkAddrSize(addr, size)
+	if err != nil {
+		return nil, err
+	}
+	if addr.separateFile() {
+		return readAddrSize(addr, w.dir, size)
+	}
+	return readAt(w.files[addr.fileNumber()].file, addr.blockOffset(), size)
+}
+
+func (w *blockWriter) openFile(addr Addr) (*os.File, error) {
+	return os.Open(path.Join(w.dir, addr.fileName()))
+}
+
+// Write writes the entry and its child entries storing its sparse data.
+func (w *blockWriter) Write(data *EntryData) (bool, error) {
+	err := checkSparse(data.Sparse)
+	if err != nil {
+		return false, fmt.Errorf("write %s: %w", data.Key, err)
+	}
+
+	old, prev, err := w.find(data.Key)
+	if err != nil {
+		return false, fmt.Errorf("write %s: %w", data.Key, err)
+	}
+	if old != nil {
+		stream, err := readStream(old, 0)
+		if err != nil {
+			return false, fmt.Errorf("write %s: %w", data.Key, err)
+		}
+		ok, err := newer(data, stream)
+		if err == nil && ok {
+			err = w.remove(old, prev)
+		}
+		if err != nil {
+			return false, fmt.Errorf("write %s: %w", data.Key, err)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+
+	streams, flags := data.Streams, uint32(0)
+	if len(data.Sparse) != 0 {
+		streams[2], err = w.writeSparse(data)
+		if err != nil {
+			return false, fmt.Errorf("write %s: %w", data.Key, err)
+		}
+		flags = parentEntry
+	}
+	err = w.add(data, streams, flags)
+	if err != nil {
+		return false, fmt.Errorf("write %s: %w", data.Key, err)
+	}
+	return true, nil
+}
+
+// add adds the entry of data, with streams, to the index and to the
+// rankings list of its reuse count.
+func (w *blockWriter) add(data *EntryData, streams [4][]byte, flags uint32) error {
+	// the entries of a simple cache have no creation time
+	created := data.Created
+	if created.IsZero() {
+		created = data.LastModified
+	}
+
+	store := entryStore{
+		Hash:         superFastHash([]byte(data.Key)),
+		ReuseCount:   data.ReuseCount,
+		RefetchCount: data.RefetchCount,
+		CreationTime: chromeTimestamp(created),
+		KeyLen:       int32(len(data.Key)),
+		Flags:        flags,
+	}
+	copy(store.Key[:], data.Key)
+
+	for i, stream := range streams {
+		addr, err := w.writeStream(stream)
+		if err != nil {
+			return err
+		}
+		store.DataSize[i], store.DataAddr[i] = int32(len(stream)), addr
+		w.index.NumBytes += int32(len(stream))
+	}
+
+	// the long keys are null terminated, in the next blocks of the entry
+	// or, if longer than 4 blocks, at the LongKey address
+	var tail []byte
+	blocks := 1
+	switch n := int32(len(data.Key)); {
+	case n > maxInternalKeyLen:
+		var err error
+		store.LongKey, err = w.writeStream(append([]byte(data.Key), 0))
+		if err != nil {
+			return err
+		}
+	case n >= blockKeyLen:
+		tail = append([]byte(data.Key[blockKeyLen:]), 0)
+		blocks += (len(tail) + entryStoreSize - 1) / entryStoreSize
+	}
+
+	addr, err := w.allocate(1, blocks)
+	if err != nil {
+		return err
+	}
+	store.RankingsNode, err = w.allocate(0, 1)
+	if err != nil {
+		return err
+	}
+
+	bucket := store.Hash & uint32(len(w.table)-1)
+	store.Next = w.table[bucket]
+	err = w.writeEntry(addr, &store)
+	if err != nil {
+		return err
+	}
+	if tail != nil {
+		_, err = w.files[1].file.WriteAt(tail, addr.blockOffset()+int64(entryStoreSize))
+		if err != nil {
+			return err
+		}
+	}
+	w.table[bucket] = addr
+	w.index.NumEntries++
+
+	node := rankingsNode{
+		LastUsed:     chromeTimestamp(data.LastUsed),
+		LastModified: chromeTimestamp(data.LastModified),
+		Contents:     addr,
+	}
+	return w.insert(store.RankingsNode, &node, lruList(store.ReuseCount))
+}
+
+// writeStream writes a stream in the block file of its size,
+// or in a new separate file if larger than 4 blocks of 4 KB.
+func (w *blockWriter) writeStream(b []byte) (Addr, error) {
+	var number int
+	switch size := len(b); {
+	case size == 0:
+		return 0, nil
+	case size < 1024:
+		number = 1
+	case size < 4096:
+		number = 2
+	case size <= 4*4096:
+		number = 3
+	default:
+		return w.writeSeparate(b)
+	}
+
+	blockSize := int(w.files[number].header.EntrySize)
+	addr, err := w.allocate(number, (len(b)+blockSize-1)/blockSize)
+	if err != nil {
+		return 0, err
+	}
+	_, err = w.files[number].file.WriteAt(b, addr.blockOffset())
+	return addr, err
+}
+
+// writeSeparate writes b in the separate file following the last one.
+func (w *blockWriter) writeSeparate(b []byte) (Addr, error) {
+	for {
+		w.index.LastFile++
+		addr := Addr(initializedMask | uint32(w.index.LastFile)&fileNameMask)
+		file, err := os.OpenFile(path.Join(w.dir, addr.fileName()), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		_, err = file.Write(b)
+		if e := file.Close(); e != nil && err == nil {
+			err = e
+		}
+		return addr, err
+	}
+}
+
+// checkSparse returns ErrUnalignedSparse if the sparse data can not be
+// stored in child entries. As Chromium does, the data of a child is
+// tracked by blocks of 1 KB, and one block only, recorded as its last
+// block, may be partial.
+func checkSparse(sparse []SparseRange) error {
+	partial := make(map[int64]bool) // [child]has a partial block
+	for _, r := range sparse {
+		if len(r.Data) == 0 {
+			continue
+		}
+		unaligned := r.Offset < 0 || r.Offset%sparseBlockSize != 0
+		if end := r.Offset + int64(len(r.Data)); !unaligned && end%sparseBlockSize != 0 {
+			child := end / sparseChildSize
+			unaligned = partial[child]
+			partial[child] = true
+		}
+		if unaligned {
+			return fmt.Errorf("sparse range at %d of %d bytes: %w", r.Offset, len(r.Data), ErrUnalignedSparse)
+		}
+	}
+	return nil
+}
+
+// writeSparse writes the child entries storing the sparse data,
+// checked by checkSparse, and returns the sparse header of the parent
+// entry, followed by the bitmap of its children.
+func (w *blockWriter) writeSparse(data *EntryData) ([]byte, error) {
+	header := sparseHeader{
+		Signature:    int64(chromeTimestamp(time.Now())),
+		Magic:        magicNumber,
+		ParentKeyLen: int32(len(data.Key)),
+	}
+
+	// the ranges stored by each child, relative to the child
+	children := make(map[int][]SparseRange)
+	for _, r := range data.Sparse {
+		for offset, end := r.Offset, r.Offset+int64(len(r.Data)); offset < end; {
+			child := offset / sparseChildSize
+			next := min(end, (child+1)*sparseChildSize)
+			children[int(child)] = append(children[int(child)], SparseRange{
+				Offset: offset - child*sparseChildSize,
+				Data:   r.Data[offset-r.Offset : next-r.Offset],
+			})
+			offset = next
+		}
+	}
+
+	bitmap := make([]byte, 128)
+	for _, child := range slices.Sorted(maps.Keys(children)) {
+		for child/8 >= len(bitmap) {
+			bitmap = append(bitmap, make([]byte, 4)...)
+		}
+		bitmap[child/8] |= 1 << (child % 8)
+
+		var stream []byte
+		blocks := make([]byte, sparseChildSize/sparseBlockSize/8)
+		childHeader := header
+		for _, r := range children[child] {
+			end := r.Offset + int64(len(r.Data))
+			if int64(len(stream)) < end {
+				stream = append(stream, make([]byte, end-int64(len(stream)))...)
+			}
+			copy(stream[r.Offset:], r.Data)
+
+			first := (r.Offset + sparseBlockSize - 1) / sparseBlockSize
+			for block := first; block < end/sparseBlockSize; block++ {
+				blocks[block/8] |= 1 << (block % 8)
+			}
+			if end%sparseBlockSize != 0 {
+				childHeader.LastBlock = int32(end / sparseBlockSize)
+				childHeader.LastBlockLen = int32(end % sparseBlockSize)
+			}
+		}
+
+		index, err := binary.Append(nil, binary.LittleEndian, &childHeader)
+		if err != nil {
+			return nil, err
+		}
+		childData := EntryData{
+			Key:          childKey(data.Key, header.Signature, child),
+			Created:      data.Created,
+			LastUsed:     data.LastUsed,
+			LastModified: data.LastModified,
+		}
+		err = w.add(&childData, [4][]byte{1: stream, 2: append(index, blocks...)}, childEntry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := binary.Append(nil, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, bitmap...), nil
+}
+
+// find returns the entry of key, and the entry chained before it,
+// zero if the entry is the first of its bucket.
+// A nil entry is returned if the key is not found.
+func (w *blockWriter) find(key string) (*Entry, Addr, error) {
+	hash := superFastHash([]byte(key))
+	addr, prev := w.table[hash&uint32(len(w.table)-1)], Addr(0)
+
+	var seen []Addr
+	for addr.initialized() && !slices.Contains(seen, addr) {
+		seen = append(seen, addr)
+		entry, err := openEntry(addr, w.dir, w)
+		if err != nil {
+			return nil, 0, err
+		}
+		if entry.Hash == hash && entry.State == 0 && entry.URL() == key {
+			return entry, prev, nil
+		}
+		addr, prev = entry.Next, addr
+	}
+	return nil, 0, nil
+}
+
+// remove removes the entry from its bucket and from its rankings list,
+// frees its blocks and removes its separate files, and its child entries.
+func (w *blockWriter) remove(entry *Entry, prev Addr) error {
+	if prev == 0 {
+		w.table[entry.Hash&uint32(len(w.table)-1)] = entry.Next
+	} else {
+		prevEntry, err := openEntry(prev, w.dir, w)
+		if err != nil {
+			return err
+		}
+		prevEntry.Next = entry.Next
+		err = w.writeEntry(prev, prevEntry.entryStore)
+		if err != nil {
+			return err
+		}
+	}
+
+	node, err := entry.rankingsNode()
+	if err != nil {
+		return err
+	}
+	err = w.unlink(entry.RankingsNode, node, lruList(entry.ReuseCount))
+	if err != nil {
+		return err
+	}
+
+	var sparse []byte
+	if entry.Flags&parentEntry != 0 {
+		sparse, err = readStream(entry, 2)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.files[entry.addr.fileNumber()].free(entry.addr)
+	w.files[entry.RankingsNode.fileNumber()].free(entry.RankingsNode)
+	for i, addr := range entry.DataAddr {
+		err = w.release(addr)
+		if err != nil {
+			return err
+		}
+		w.index.NumBytes -= entry.DataSize[i]
+	}
+	err = w.release(entry.LongKey)
+	if err != nil {
+		return err
+	}
+	w.index.NumEntries--
+
+	if sparse != nil {
+		return w.removeChildren(entry.URL(), sparse)
+	}
+	return nil
+}
+
+// release frees the blocks of addr, or removes its separate file.
+func (w *blockWriter) release(addr Addr) error {
+	switch {
+	case !addr.initialized():
+	case addr.separateFile():
+		err := os.Remove(path.Join(w.dir, addr.fileName()))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	default:
+		w.files[addr.fileNumber()].free(addr)
+	}
+	return nil
+}
+
+// removeChildren removes the child entries of the entry key,
+// listed by its sparse header.
+func (w *blockWriter) removeChildren(key string, b []byte) error {
+	header, children, err := readSparseHeader(b)
+	if err != nil {
+		return err
+	}
+	for child := range len(children) * 8 {
+		if children[child/8]&(1<<(child%8)) == 0 {
+			continue
+		}
+		entry, prev, err := w.find(childKey(key, header.Signature, child))
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+		err = w.remove(entry, prev)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lruList returns the rankings list of an entry reused count times.
+func lruList(count int32) int {
+	switch {
+	case count == 0:
+		return lruNoUse
+	case count < highUse:
+		return lruLowUse
+	}
+	return lruHighUse
+}
+
+// insert inserts the node at addr at the head of the list.
+func (w *blockWriter) insert(addr Addr, node *rankingsNode, list int) error {
+	lru := &w.index.Lru
+	head := lru.Heads[list]
+
+	node.Prev, node.Next = addr, addr
+	if head.initialized() {
+		headNode, err := w.readNode(head)
+		if err != nil {
+			return err
+		}
+		headNode.Prev = addr
+		err = w.writeNode(head, headNode)
+		if err != nil {
+			return err
+		}
+		node.Next = head
+	} else {
+		lru.Tails[list] = addr
+	}
+	lru.Heads[list] = addr
+	lru.Sizes[list]++
+	return w.writeNode(addr, node)
+}
+
+// unlink removes the node at addr from its list, the list of its head
+// or tail if any, or list otherwise.
+func (w *blockWriter) unlink(addr Addr, node *rankingsNode, list int) error {
+	lru := &w.index.Lru
+	for i := range lru.Heads {
+		if lru.Heads[i] == addr || lru.Tails[i] == addr {
+			list = i
+		}
+	}
+	head, tail := lru.Heads[list] == addr, lru.Tails[list] == addr
+
+	// the neighbours, pointing to themselves at the ends of the list
+	update := func(at Addr, set func(n *rankingsNode)) error {
+		n, err := w.readNode(at)
+		if err != nil {
+			return err
+		}
+		set(n)
+		return w.writeNode(at, n)
+	}
+
+	var err error
+	switch {
+	case head && tail:
+		lru.Heads[list], lru.Tails[list] = 0, 0
+	case head:
+		lru.Heads[list] = node.Next
+		err = update(node.Next, func(n *rankingsNode) { n.Prev = node.Next })
+	case tail:
+		lru.Tails[list] = node.Prev
+		err = update(node.Prev, func(n *rankingsNode) { n.Next = node.Prev })
+	default:
+		err = update(node.Prev, func(n *rankingsNode) { n.Next = node.Next })
+		if err == nil {
+			err = update(node.Next, func(n *rankingsNode) { n.Prev = node.Prev })
+		}
+	}
+	if err != nil {
+		return err
+	}
+	lru.Sizes[list]--
+	return nil
+}
+
+func (w *blockWriter) readNode(addr Addr) (*rankingsNode, error) {
+	b, err := w.readAddrSize(addr, int32(addr.blockSize()))
+	if err != nil {
+		return nil, err
+	}
+	var node rankingsNode
+	err = binary.Read(bytes.NewReader(b), binary.LittleEndian, &node)
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// writeNode writes the node at addr, with its self hash.
+func (w *blockWriter) writeNode(addr Addr, node *rankingsNode) error {
+	b, err := binary.Append(nil, binary.LittleEndian, node)
+	if err != nil {
+		return err
+	}
+	node.SelfHash = superFastHash(b[:rankingsSelfHashOffset])
+	binary.LittleEndian.PutUint32(b[rankingsSelfHashOffset:], node.SelfHash)
+	_, err = w.files[addr.fileNumber()].file.WriteAt(b, addr.blockOffset())
+	return err
+}
+
+// writeEntry writes the entry at addr, with its self hash.
+func (w *blockWriter) writeEntry(addr Addr, store *entryStore) error {
+	b, err := binary.Append(nil, binary.LittleEndian, store)
+	if err != nil {
+		return err
+	}
+	store.SelfHash = superFastHash(b[:entrySelfHashOffset])
+	binary.LittleEndian.PutUint32(b[entrySelfHashOffset:], store.SelfHash)
+	_, err = w.files[addr.fileNumber()].file.WriteAt(b, addr.blockOffset())
+	return err
+}
+
+// Close writes the headers of the block files and the index.
+func (w *blockWriter) Close() error {
+	var err error
+	for _, f := range w.files {
+		b, e := binary.Append(nil, binary.LittleEndian, &f.header)
+		if e == nil {
+			_, e = f.file.WriteAt(b, 0)
+		}
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	if e := w.closeFiles(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		return fmt.Errorf("close writer: %w", err)
+	}
+
+	b, err := binary.Append(nil, binary.LittleEndian, &w.index)
+	if err == nil {
+		b, err = binary.Append(b, binary.LittleEndian, w.table)
+	}
+	if err == nil {
+		err = os.WriteFile(path.Join(w.dir, "index"), b, 0o600)
+	}
+	if err != nil {
+		return fmt.Errorf("close writer: %w", err)
+	}
+	return nil
+}
+
+// closeFiles closes the open block files.
+func (w *blockWriter) closeFiles() error {
+	var err error
+	for _, f := range w.files {
+		if f == nil {
+			continue
+		}
+		if e := f.file.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
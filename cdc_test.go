@@ -1,10 +1,16 @@
 package cdc_test
 
 import (
+	"errors"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/schorlet/cdc"
 )
@@ -65,3 +71,241 @@ func TestBadURL(t *testing.T) {
 		t.Fatalf("err: %v, want: %v", err, cdc.ErrNotFound)
 	}
 }
+
+func TestEntryError(t *testing.T) {
+	_, err := cdc.OpenEntry(cdc.Addr(0x90000005), "testdata") // rankings node
+	if !errors.Is(err, cdc.ErrInvalidAddr) {
+		t.Fatalf("err: %v, want: %v", err, cdc.ErrInvalidAddr)
+	}
+
+	var entryErr *cdc.EntryError
+	if !errors.As(err, &entryErr) {
+		t.Fatalf("err: %T, want: %T", err, entryErr)
+	}
+	if entryErr.Addr != 0x90000005 {
+		t.Fatalf("addr: %d, want: %d", entryErr.Addr, 0x90000005)
+	}
+}
+
+func TestOpenCacheWithOptions(t *testing.T) {
+	cache, err := cdc.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	urls := cache.URLs()
+
+	for _, opts := range []cdc.Options{
+		{Strict: true, Workers: 4},
+		{Strict: true, Lazy: true},
+	} {
+		func() {
+			other, err := cdc.OpenCacheWithOptions("testdata", &opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer other.Close()
+
+			for _, url := range urls {
+				addr, err := other.GetAddr(url)
+				if err != nil {
+					t.Fatalf("%+v: %v", opts, err)
+				}
+				want, _ := cache.GetAddr(url)
+				if addr != want {
+					t.Fatalf("%+v: addr: %d, want: %d", opts, addr, want)
+				}
+			}
+
+			_, err = other.GetAddr("http://foo.com")
+			if err != cdc.ErrNotFound {
+				t.Fatalf("%+v: err: %v, want: %v", opts, err, cdc.ErrNotFound)
+			}
+
+			if !slices.Equal(other.URLs(), urls) {
+				t.Fatalf("%+v: urls: %v, want: %v", opts, other.URLs(), urls)
+			}
+		}()
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	opts := cdc.Options{Workers: 4, Mmap: true}
+	cache, err := cdc.OpenCacheWithOptions("testdata", &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := cache.URLs()
+
+	var wg sync.WaitGroup
+	for _, url := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			entry, err := cache.OpenURL(url)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			body, err := entry.Body()
+			if err != nil {
+				t.Errorf("body: %v", err)
+				return
+			}
+			defer body.Close()
+
+			n, err := io.Copy(ioutil.Discard, body)
+			if err != nil {
+				t.Errorf("discard body: %v", err)
+			}
+			if n != int64(entry.DataSize[1]) {
+				t.Errorf("body stream-length: %d, want: %d", n, entry.DataSize[1])
+			}
+		}()
+	}
+	wg.Wait()
+
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cache.OpenURL(urls[0])
+	if !errors.Is(err, cdc.ErrClosed) {
+		t.Fatalf("err: %v, want: %v", err, cdc.ErrClosed)
+	}
+}
+
+func TestEntries(t *testing.T) {
+	cache, err := cdc.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	var urls []string
+	for entry, err := range cache.Entries() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, err := cache.GetAddr(entry.URL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Addr() != addr {
+			t.Fatalf("addr: %d, want: %d", entry.Addr(), addr)
+		}
+		if entry.Created().IsZero() {
+			t.Fatalf("creation time of %s is zero", entry.URL())
+		}
+		urls = append(urls, entry.URL())
+	}
+
+	if !slices.Equal(urls, cache.URLs()) {
+		t.Fatalf("urls: %v, want: %v", urls, cache.URLs())
+	}
+}
+
+func TestSearch(t *testing.T) {
+	cache, err := cdc.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	jquery := "https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js"
+	queries := []struct {
+		query cdc.Query
+		urls  []string
+	}{
+		{cdc.Query{URL: "jquery.min"}, []string{jquery}},
+		{cdc.Query{URL: "jquery", MinSize: 30000}, []string{jquery}},
+		{cdc.Query{URL: "/pkg/", MaxSize: 1}, nil},
+		{cdc.Query{URLRegexp: regexp.MustCompile(`^https://golang\.org/pkg/.+/$`), Since: time.Now()}, nil},
+		{cdc.Query{
+			URLRegexp: regexp.MustCompile(`jquery\.min\.js$`),
+			Header:    http.Header{"Content-Type": []string{"JavaScript"}},
+			Status:    200,
+			Text:      "jQuery v1.8.2",
+		}, []string{jquery}},
+		{cdc.Query{URL: jquery, Text: "not in the body"}, nil},
+		{cdc.Query{URL: jquery, Status: 404}, nil},
+		{cdc.Query{URL: "jquery.min", Host: "googleapis.com"}, []string{jquery}},
+		{cdc.Query{URL: "jquery.min", Host: "golang.org"}, nil},
+	}
+
+	for _, q := range queries {
+		var urls []string
+		for entry, err := range cache.Search(&q.query) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			urls = append(urls, entry.URL())
+		}
+		if !slices.Equal(urls, q.urls) {
+			t.Fatalf("search %+v: %v, want: %v", q.query, urls, q.urls)
+		}
+	}
+}
+
+func TestEntryDetails(t *testing.T) {
+	cache, err := cdc.OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	entry, err := cache.OpenURL("https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := entry.Addr().Info()
+	if info.FileName != "data_1" || info.FileType != "block_256" || info.NumBlocks != 1 {
+		t.Fatalf("addr info: %+v", info)
+	}
+
+	rankings, err := entry.Rankings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rankings.LastUsed.Before(entry.Created()) {
+		t.Fatalf("last used %v before creation %v", rankings.LastUsed, entry.Created())
+	}
+
+	response, err := entry.ResponseInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values int
+	for _, v := range response.Header {
+		values += len(v)
+	}
+	if n := len(response.HeaderFields); n == 0 || n != values {
+		t.Fatalf("header fields: %d, want: %d", n, values)
+	}
+	if field := response.HeaderFields[0]; response.Header.Get(field.Name) != field.Value {
+		t.Fatalf("header field %+v not in header", field)
+	}
+
+	sizes := []int64{4076, 33397, 152728, 0}
+	for i, size := range sizes {
+		stream, err := entry.Stream(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := io.Copy(ioutil.Discard, stream)
+		_ = stream.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != size {
+			t.Fatalf("stream %d size: %d, want: %d", i, n, size)
+		}
+	}
+
+	_, err = entry.Stream(4)
+	if !errors.Is(err, cdc.ErrInvalidAddr) {
+		t.Fatalf("stream 4: %v, want: %v", err, cdc.ErrInvalidAddr)
+	}
+}
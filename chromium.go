@@ -11,20 +11,31 @@ package cdc
 // http://chip-dfir.techanarchy.net/?p=8
 
 import (
-	"encoding/binary"
 	"fmt"
-	"log"
+	"time"
 )
 
 // IndexHeader
 const magicNumber uint32 = 0xc103cac3
+const indexHeaderSize int = 368
+const indexTableSize int32 = 0x10000 // default size of the table
+const indexVersion uint32 = 0x20001  // version 2.1, with the new eviction
 
 // BlockFileHeader
+const blockMagic uint32 = 0xc104cac3
+const blockVersion uint32 = 0x20000
 const blockHeaderSize int = 8192
 const maxBlocks int = (blockHeaderSize - 80) * 8
+const blockGrowth int32 = 1024 // blocks added when a block-file is full
 
 // EntryStore
+const entryStoreSize int = 256
 const blockKeyLen int32 = 256 - 24*4
+const maxInternalKeyLen int32 = 4*256 - 24*4 - 1 // in the 4 blocks of an entry
+
+// EntryFlags
+const parentEntry uint32 = 1 // This entry has children (sparse) entries.
+const childEntry uint32 = 2  // Child entry that stores sparse data.
 
 // Addr
 const initializedMask uint32 = 0x80000000
@@ -36,6 +47,30 @@ const fileSelectorOffset uint32 = 16
 const startBlockMask uint32 = 0x0000ffff
 const numBlocksMask uint32 = 0x03000000
 const numBlocksOffset uint32 = 24
+const reservedBitsMask uint32 = 0x0c000000
+
+// Time
+// Microseconds between the Windows epoch (1601-01-01) and the Unix epoch.
+const windowsEpochDelta int64 = 11644473600 * 1000000
+
+// chromeTime returns the time of t, a number of microseconds
+// since the Windows epoch, as base::Time stores it.
+// The zero value is returned if t is zero.
+func chromeTime(t uint64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(int64(t) - windowsEpochDelta).UTC()
+}
+
+// chromeTimestamp returns t as a number of microseconds
+// since the Windows epoch, the inverse of chromeTime.
+func chromeTimestamp(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixMicro() + windowsEpochDelta)
+}
 
 // indexHeader for the master index file.
 type indexHeader struct {
@@ -51,9 +86,34 @@ type indexHeader struct {
 	Experiment int32  // Id of an ongoing test.
 	CreateTime uint64 // Creation time for this set of files.
 	Pad        [52]int32
-	Lru        [28]int32 // Eviction control data.
+	Lru        lruData // Eviction control data.
+}
+
+// lruData holds the heads and tails of the LRU lists of the rankings nodes.
+// The head of a list is the most recently used node, the previous node
+// of the head and the next node of the tail point to themselves.
+type lruData struct {
+	Pad1          [2]int32
+	Filled        int32    // Flag to tell when we filled the cache.
+	Sizes         [5]int32 // Number of nodes of each list.
+	Heads         [5]Addr
+	Tails         [5]Addr
+	Transaction   Addr  // In-flight operation target.
+	Operation     int32 // Actual in-flight operation.
+	OperationList int32 // In-flight operation list.
+	Pad2          [7]int32
 }
 
+// The lists of the new eviction, by use of the entries.
+const (
+	lruNoUse   = 0 // Entries not reused.
+	lruLowUse  = 1 // Entries reused less than highUse times.
+	lruHighUse = 2 // Entries reused at least highUse times.
+)
+
+// highUse is the reuse count of the entries of the high use list.
+const highUse int32 = 10
+
 // blockFileHeader is the header of a block-file.
 // A block-file is the file used to store information in blocks (could be
 // EntryStore blocks, RankingsNode blocks or user-data blocks).
@@ -110,9 +170,67 @@ type entryStore struct {
 	Key          [blockKeyLen]byte // null terminated
 }
 
+// rankingsNode is the LRU node of an entry, stored in a RANKINGS block.
+type rankingsNode struct {
+	LastUsed     uint64 // LRU info.
+	LastModified uint64 // LRU info.
+	Next         Addr   // LRU list.
+	Prev         Addr   // LRU list.
+	Contents     Addr   // Address of the EntryStore.
+	Dirty        int32  // The entry is being modified.
+	SelfHash     uint32 // RankingsNode's hash.
+}
+
+// SparseHeader
+const sparseHeaderSize int = 64
+const sparseChildSize int64 = 1 << 20 // data stored by a child entry
+const sparseBlockSize int64 = 1024    // data tracked by a bit of a child bitmap
+
+// sparseHeader is the header of the sparse data of an entry, in its
+// stream 2. It is followed by the bitmap of the children of a parent entry,
+// or by the bitmap of the blocks stored by a child entry.
+type sparseHeader struct {
+	Signature    int64  // The parent and children signature.
+	Magic        uint32 // Structure identifier (equal to magicNumber).
+	ParentKeyLen int32  // Key length for the parent entry.
+	LastBlock    int32  // Index of the last written block.
+	LastBlockLen int32  // Length of the last written block.
+	Dummy        [10]int32
+}
+
 // Addr defines a storage address for an Entry.
 type Addr uint32
 
+// AddrInfo is the decoded form of an Addr.
+type AddrInfo struct {
+	Initialized bool
+	FileType    string // "external", "rankings", "block_256", "block_1k", ...
+	FileName    string // Like "data_1" or "f_000003", empty if not valid.
+	StartBlock  uint32
+	NumBlocks   uint32
+	BlockSize   uint32
+	Offset      int64 // Offset of the start block in the file.
+}
+
+// fileTypeNames are the names of the file types.
+var fileTypeNames = [...]string{
+	"external", "rankings", "block_256", "block_1k",
+	"block_4k", "block_files", "block_entries", "block_evicted",
+}
+
+// Info returns the decoded address.
+func (addr Addr) Info() AddrInfo {
+	return AddrInfo{
+		Initialized: addr.initialized(),
+		FileType:    fileTypeNames[addr.fileType()],
+		FileName:    addr.fileName(),
+		StartBlock:  addr.startBlock(),
+		NumBlocks:   addr.numBlocks(),
+		BlockSize:   addr.blockSize(),
+		Offset:      addr.blockOffset(),
+	}
+}
+
 // initialized returns the initialization state.
 func (addr Addr) initialized() bool {
 	return (uint32(addr) & initializedMask) != 0
@@ -124,6 +242,30 @@ func (addr Addr) separateFile() bool {
 	return (uint32(addr) & fileTypeMask) == 0
 }
 
+// sanityCheck returns true if the address is well formed:
+// a known file type and no reserved bits set for block files.
+func (addr Addr) sanityCheck() bool {
+	if !addr.initialized() {
+		return addr == 0
+	}
+	if addr.fileType() > 4 { // BLOCK_4K
+		return false
+	}
+	if addr.separateFile() {
+		return true
+	}
+	return uint32(addr)&reservedBitsMask == 0
+}
+
+// sanityCheckForEntry returns true if the address
+// may hold an entryStore.
+func (addr Addr) sanityCheckForEntry() bool {
+	if !addr.sanityCheck() || !addr.initialized() {
+		return false
+	}
+	return addr.fileType() == 2 // BLOCK_256
+}
+
 // fileType returns one of these values:
 //  EXTERNAL = 0,
 //  RANKINGS = 1,
@@ -145,9 +287,10 @@ func (addr Addr) fileNumber() uint32 {
 	return (uint32(addr) & fileSelectorMask) >> fileSelectorOffset
 }
 
-// fileName returns the file name.
+// fileName returns the file name,
+// or an empty string if the address is not valid.
 func (addr Addr) fileName() string {
-	if !addr.initialized() {
+	if !addr.initialized() || !addr.sanityCheck() {
 		return ""
 	}
 	if addr.separateFile() {
@@ -164,6 +307,14 @@ func (addr Addr) startBlock() uint32 {
 	return uint32(addr) & startBlockMask
 }
 
+// blockOffset returns the offset of the start block in the block file.
+func (addr Addr) blockOffset() int64 {
+	if addr.separateFile() {
+		return 0
+	}
+	return int64(addr.startBlock())*int64(addr.blockSize()) + int64(blockHeaderSize)
+}
+
 // blockSize returns the block size.
 func (addr Addr) blockSize() uint32 {
 	switch addr.fileType() {
@@ -192,20 +343,3 @@ func (addr Addr) numBlocks() uint32 {
 	}
 	return ((uint32(addr) & numBlocksMask) >> numBlocksOffset) + 1
 }
-
-func init() {
-	var ih indexHeader
-	if n := binary.Size(ih); n != 368 {
-		log.Fatalf("IndexHeader size error: %d, want: 368", n)
-	}
-
-	var bh blockFileHeader
-	if n := binary.Size(bh); n != blockHeaderSize {
-		log.Fatalf("BlockFileHeader size error: %d, want: %d", n, blockHeaderSize)
-	}
-
-	var entry entryStore
-	if n := binary.Size(entry); n != 256 {
-		log.Fatalf("EntryStore size error: %d, want: 256", n)
-	}
-}
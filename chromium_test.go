@@ -0,0 +1,38 @@
+package cdc
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestSizes(t *testing.T) {
+	var ih indexHeader
+	if n := binary.Size(ih); n != indexHeaderSize {
+		t.Fatalf("IndexHeader size error: %d, want: %d", n, indexHeaderSize)
+	}
+
+	var bh blockFileHeader
+	if n := binary.Size(bh); n != blockHeaderSize {
+		t.Fatalf("BlockFileHeader size error: %d, want: %d", n, blockHeaderSize)
+	}
+
+	var entry entryStore
+	if n := binary.Size(entry); n != 256 {
+		t.Fatalf("EntryStore size error: %d, want: 256", n)
+	}
+
+	var node rankingsNode
+	if n := binary.Size(node); n != 36 {
+		t.Fatalf("RankingsNode size error: %d, want: 36", n)
+	}
+
+	var lru lruData
+	if n := binary.Size(lru); n != 112 {
+		t.Fatalf("LruData size error: %d, want: 112", n)
+	}
+
+	var sparse sparseHeader
+	if n := binary.Size(sparse); n != sparseHeaderSize {
+		t.Fatalf("SparseHeader size error: %d, want: %d", n, sparseHeaderSize)
+	}
+}
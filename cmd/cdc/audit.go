@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/schorlet/cdc"
+)
+
+const auditUsage = `Usage:
+    cdc audit [flag] CACHEDIR
+
+Audit computes the freshness of the selected entries from their caching
+headers, following RFC 9111 for a private cache, and reports:
+    stale         entries which must be validated before reuse
+    uncacheable   entries which should not have been stored
+    suspicious    entries with questionable caching headers
+
+The entries are selected with:
+` + selectorUsage + `
+The audit flags are:
+    -now time          time of the audit, like 2016-01-09 or 2016-01-09T22:58:22Z
+                       (default the current time)
+
+The output flags are:
+    -format string     output format: text, json, jsonl, csv or tsv (default "text")
+    -fields string     comma separated fields to print, among:
+                       category, detail, ttl, addr, url
+
+The ttl is the time left before the entry is stale, negative if stale,
+in seconds in JSON. In text format, the findings are followed by the
+count of each category.
+`
+
+// Categories of the audit findings.
+const (
+	auditStale       = "stale"
+	auditUncacheable = "uncacheable"
+	auditSuspicious  = "suspicious"
+)
+
+// finding is an audit finding of an entry.
+type finding struct {
+	Category string
+	Detail   string
+	TTL      time.Duration // Negative if stale.
+	Addr     cdc.Addr
+	URL      string
+}
+
+// findingFields are the fields of the findings.
+var findingFields = []field[*finding]{
+	{"category", func(f *finding) (any, error) { return f.Category, nil }},
+	{"detail", func(f *finding) (any, error) { return f.Detail, nil }},
+	{"ttl", func(f *finding) (any, error) { return f.TTL, nil }},
+	{"addr", func(f *finding) (any, error) { return f.Addr, nil }},
+	{"url", func(f *finding) (any, error) { return f.URL, nil }},
+}
+
+func audit(args []string) {
+	var sel selection
+	var out output[*finding]
+	var now time.Time
+
+	flags := newFlagSet("audit")
+	sel.addFlags(flags, false)
+	flags.Func("now", "", func(v string) (err error) {
+		now, err = parseTime(v)
+		return err
+	})
+	out.addFlags(flags, findingFields, "category,detail,ttl,addr,url", "category,detail,ttl,addr,url")
+	cachedir := parseArgs(flags, args, 1)[0]
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	var findings []finding
+	counts := make(map[string]int)
+	cache := openCache(cachedir, sel.snapshot)
+	ok := true
+	for entry, err := range sel.entries(cache) {
+		if err == nil && entry.Child() {
+			continue
+		}
+		var info *cdc.ResponseInfo
+		if err == nil {
+			info, err = entry.ResponseInfo()
+		}
+		if err != nil {
+			log.Print(err)
+			ok = false
+			continue
+		}
+
+		f := info.Freshness(now)
+		add := func(category, detail string) {
+			findings = append(findings, finding{
+				Category: category, Detail: detail, TTL: f.TTL.Round(time.Second),
+				Addr: entry.Addr(), URL: entry.URL(),
+			})
+		}
+		switch {
+		case !f.Cacheable:
+			add(auditUncacheable, f.Reason)
+			counts[auditUncacheable]++
+		case !f.Fresh:
+			add(auditStale, f.Reason)
+			counts[auditStale]++
+		default:
+			counts["fresh"]++
+		}
+		details := suspicious(info, f)
+		for _, detail := range details {
+			add(auditSuspicious, detail)
+		}
+		if len(details) != 0 {
+			counts[auditSuspicious]++
+		}
+	}
+	_ = cache.Close()
+
+	err := printFindings(&out, findings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if out.format == "text" {
+		fmt.Printf("%d fresh, %d stale, %d uncacheable, %d suspicious\n",
+			counts["fresh"], counts[auditStale], counts[auditUncacheable], counts[auditSuspicious])
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// suspicious returns the questionable caching headers of the response.
+func suspicious(info *cdc.ResponseInfo, f *cdc.Freshness) []string {
+	var details []string
+	header := info.Header
+
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		details = append(details, "no valid Date header")
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		switch {
+		case err != nil:
+			details = append(details, fmt.Sprintf("invalid Expires %q", expires))
+		case f.Reason == cdc.ReasonMaxAge && !date.IsZero():
+			// Expires is ignored, but used by older caches
+			if d := t.Sub(date) - f.Lifetime; d > 24*time.Hour || d < -24*time.Hour {
+				details = append(details, "max-age and Expires differ by more than a day")
+			}
+		}
+	}
+	if f.Reason == cdc.ReasonExpires && f.Lifetime == 0 {
+		details = append(details, "expired when received")
+	}
+	if f.Immutable && f.Reason != cdc.ReasonMaxAge {
+		details = append(details, "immutable without max-age")
+	}
+	if f.SharedMaxAge && f.Reason != cdc.ReasonMaxAge {
+		details = append(details, "s-maxage without max-age")
+	}
+	if f.Reason == cdc.ReasonHeuristic {
+		details = append(details, fmt.Sprintf("heuristic lifetime of %v", f.Lifetime.Round(time.Second)))
+	}
+	for _, name := range f.Vary {
+		if name == "User-Agent" || name == "Cookie" {
+			details = append(details, "vary on "+name)
+		}
+	}
+	if header.Get("Set-Cookie") != "" && f.Cacheable && f.Reason != cdc.ReasonNoCache {
+		details = append(details, "Set-Cookie in a reusable response")
+	}
+	return details
+}
+
+// printFindings prints the findings with out.
+func printFindings(out *output[*finding], findings []finding) error {
+	err := out.begin(os.Stdout)
+	if err != nil {
+		return err
+	}
+	for i := range findings {
+		err = out.print(&findings[i])
+		if err != nil {
+			return err
+		}
+	}
+	return out.end()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/schorlet/cdc"
+)
+
+const convertUsage = `Usage:
+    cdc convert [flag] SRC DST
+
+Convert writes all the entries of the SRC cache into a new DST cache in
+the other format, with their key, response info, streams, sparse data and
+last used time. SRC is first read to check that all its entries can be
+written without loss, DST is not created otherwise: a blockfile cache
+stores the sparse data by blocks of 1 KB, and can not store the ranges
+which do not start and end on a block, except at the end of the data.
+DST is then read back to check that it holds the same entries.
+
+The convert flags are:
+    -to format         format of DST: blockfile or simple
+                       (default the format which SRC does not have)
+`
+
+func convert(args []string) {
+	var to string
+
+	flags := newFlagSet("convert")
+	flags.StringVar(&to, "to", "", "")
+	dirs := parseArgs(flags, args, 2)
+	src, dst := dirs[0], dirs[1]
+
+	from, err := cdc.DetectFormat(src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	format := cdc.Simple
+	if from == cdc.Simple {
+		format = cdc.Blockfile
+	}
+	if to != "" {
+		format, err = cdc.ParseFormat(to)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if _, err := cdc.DetectFormat(dst); err == nil {
+		log.Fatalf("convert: %s already holds a cache", dst)
+	}
+
+	ok := true
+	for data, err := range cdc.ReadEntries(src) {
+		if err == nil {
+			if err = cdc.CheckEntryData(data, format); err != nil {
+				err = fmt.Errorf("convert %s: %w", data.Key, err)
+			}
+		}
+		if err != nil {
+			log.Print(err)
+			ok = false
+		}
+	}
+	if !ok {
+		log.Fatalf("convert: %s can not be converted to %s", src, format)
+	}
+
+	w, err := cdc.NewWriter(dst, format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	written := make(map[string]summary)
+	for data, err := range cdc.ReadEntries(src) {
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		if err != nil {
+			log.Print(err)
+			ok = false
+			continue
+		}
+		written[data.Key] = summarize(data)
+	}
+	err = w.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// validation
+	n := 0
+	for data, err := range cdc.ReadEntries(dst) {
+		if err != nil {
+			log.Printf("convert: invalid entry: %v", err)
+			ok = false
+			continue
+		}
+		if w, found := written[data.Key]; !found || !w.matches(summarize(data)) {
+			log.Printf("convert: entry %s differs", data.Key)
+			ok = false
+		}
+		delete(written, data.Key)
+		n++
+	}
+	for key := range written {
+		log.Printf("convert: entry %s missing", key)
+		ok = false
+	}
+
+	fmt.Printf("%d entries converted to %s\n", n, format)
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// summary is the hash of the key, streams and sparse data of an entry,
+// with its last used time.
+type summary struct {
+	sum      [sha256.Size]byte
+	lastUsed time.Time
+}
+
+func summarize(data *cdc.EntryData) summary {
+	hash := sha256.New()
+	write := func(b []byte) {
+		_ = binary.Write(hash, binary.LittleEndian, int64(len(b)))
+		hash.Write(b)
+	}
+
+	write([]byte(data.Key))
+	for _, stream := range data.Streams {
+		write(stream)
+	}
+	// the adjacent ranges are hashed as one, as read from a blockfile cache
+	for i := 0; i < len(data.Sparse); {
+		r := data.Sparse[i]
+		run := slices.Clone(r.Data)
+		for i++; i < len(data.Sparse) && data.Sparse[i].Offset == r.Offset+int64(len(run)); i++ {
+			run = append(run, data.Sparse[i].Data...)
+		}
+		_ = binary.Write(hash, binary.LittleEndian, r.Offset)
+		write(run)
+	}
+
+	s := summary{lastUsed: data.LastUsed}
+	hash.Sum(s.sum[:0])
+	return s
+}
+
+// matches reports whether the entry read back matches the written entry s.
+// A zero last used time is not compared, the simple format stores it as
+// the modification time of the files.
+func (s summary) matches(read summary) bool {
+	return s.sum == read.sum &&
+		(s.lastUsed.IsZero() || s.lastUsed.UnixMicro() == read.lastUsed.UnixMicro())
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"iter"
+	"log"
+	"os"
+
+	"github.com/schorlet/cdc"
+)
+
+const copyUsage = `Usage:
+    cdc copy [flag] SRC DST
+
+Copy writes the selected entries of the SRC cache into the DST cache,
+all of them by default, with their key, response info, streams and sparse
+data. An entry of DST with the same URL is replaced if its response is
+older. DST is created if it does not hold a cache.
+
+` + selectUsage + `
+The copy flags are:
+    -to format         format of DST if created: blockfile or simple (default "blockfile")
+`
+
+func copyEntries(args []string) {
+	var sel selection
+	var to string
+
+	flags := newFlagSet("copy")
+	sel.addFlags(flags, true)
+	flags.StringVar(&to, "to", "", "")
+	dirs := parseArgs(flags, args, 2)
+
+	format := cdc.Blockfile
+	if to != "" {
+		var err error
+		format, err = cdc.ParseFormat(to)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if existing, err := cdc.DetectFormat(dirs[1]); err == nil {
+		format = existing
+	}
+
+	src := openCache(dirs[0], sel.snapshot)
+	w, err := cdc.NewWriter(dirs[1], format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	copied, kept, ok := copyAll(w, src, sel.entries(src))
+	_ = src.Close()
+	err = w.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%d copied, %d kept\n", copied, kept)
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// copyAll writes the entries of src with w, logging the errors. It returns
+// the number of entries written, and kept as older than the existing
+// ones, and reports whether all the entries were read and written.
+func copyAll(w cdc.Writer, src *cdc.Cache, entries iter.Seq2[*cdc.Entry, error]) (int, int, bool) {
+	copied, kept, ok := 0, 0, true
+	for entry, err := range entries {
+		if err == nil && entry.Child() {
+			continue // written with its parent
+		}
+
+		var data *cdc.EntryData
+		if err == nil {
+			data, err = src.EntryData(entry)
+		}
+		var written bool
+		if err == nil {
+			written, err = w.Write(data)
+		}
+
+		switch {
+		case err != nil:
+			log.Print(err)
+			ok = false
+		case written:
+			copied++
+		default:
+			kept++
+		}
+	}
+	return copied, kept, ok
+}
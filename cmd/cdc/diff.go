@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/schorlet/cdc"
+)
+
+const diffUsage = `Usage:
+    cdc diff [flag] OLD NEW
+
+Diff compares the entries of the OLD and NEW caches by URL, and lists
+the entries added (+), removed (-) and updated (~), with the fields
+which differ among created, size, header and body. The entries which
+could not be read are listed with ? instead of their fields, and their
+error is logged.
+
+The entries are selected, in NEW if present, with:
+` + selectorUsage + `
+The output flags are:
+    -format string     output format: text, json, jsonl, csv or tsv (default "text")
+    -fields string     comma separated fields to print, among:
+                       change, type, url, old, new, fields, error
+`
+
+// diffFields are the fields of the differences.
+var diffFields = []field[*cdc.Difference]{
+	{"change", func(d *cdc.Difference) (any, error) {
+		return map[cdc.EventType]string{
+			cdc.EntryAdded:   "+",
+			cdc.EntryRemoved: "-",
+			cdc.EntryUpdated: "~",
+		}[d.Type], nil
+	}},
+	{"type", func(d *cdc.Difference) (any, error) { return d.Type.String(), nil }},
+	{"url", func(d *cdc.Difference) (any, error) { return d.URL, nil }},
+	{"old", func(d *cdc.Difference) (any, error) { return d.Old, nil }},
+	{"new", func(d *cdc.Difference) (any, error) { return d.New, nil }},
+	{"fields", func(d *cdc.Difference) (any, error) {
+		if d.Err != nil {
+			return []string{"?"}, nil
+		}
+		return d.Fields, nil
+	}},
+	{"error", func(d *cdc.Difference) (any, error) {
+		if d.Err != nil {
+			return d.Err.Error(), nil
+		}
+		return "", nil
+	}},
+}
+
+func diff(args []string) {
+	var sel selection
+	var out output[*cdc.Difference]
+
+	flags := newFlagSet("diff")
+	sel.addFlags(flags, false)
+	out.addFlags(flags, diffFields, "change,url,fields", "type,url,old,new,fields,error")
+	dirs := parseArgs(flags, args, 2)
+
+	oldCache := openCache(dirs[0], sel.snapshot)
+	newCache := openCache(dirs[1], sel.snapshot)
+
+	var query *cdc.Query
+	if !sel.empty() {
+		query = &sel.query
+	}
+	diffs := cdc.DiffQuery(oldCache, newCache, query)
+	_ = oldCache.Close()
+	_ = newCache.Close()
+
+	err := out.begin(os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ok := true
+	for _, d := range diffs {
+		if d.Err != nil {
+			log.Printf("diff %s: %v", d.URL, d.Err)
+			ok = false
+		}
+		err = out.print(&d)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	err = out.end()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/schorlet/cdc"
+)
+
+const duUsage = `Usage:
+    cdc du [flag] CACHEDIR
+
+Du reports the disk usage of the selected entries, all of them by default:
+the size of their headers and of their bodies by host, MIME type, type of
+file and age. It also reports the counters of the index, and the use of
+the blocks of the block files, for the whole cache.
+
+In text format, without -fields, the usage is printed as a report.
+Otherwise, it is printed as rows of a group: total, errors, index, host,
+type, file, age or block, with their name among the group.
+
+The flags are:
+    -top int           number of hosts and MIME types printed in the
+                       report, the largest ones, 0 for all (default 10)
+
+The entries are selected with:
+` + selectorUsage + `
+The output flags are:
+    -format string     output format: text, json, jsonl, csv or tsv (default "text")
+    -fields string     comma separated fields to print, among:
+                       group, name, entries, header, body, bytes, blockSize,
+                       blocks, used, empty, stale, fragmentation
+`
+
+// usageRow is a row of the disk usage. The block files have no header
+// and body, their entries are their allocations.
+type usageRow struct {
+	group, name         string
+	entries             int
+	header, body, bytes int64
+	file                *cdc.BlockFileUsage
+}
+
+// blockField returns a field of the block files, nil for the other rows.
+func blockField(name string, value func(b *cdc.BlockFileUsage) any) field[*usageRow] {
+	return field[*usageRow]{name, func(r *usageRow) (any, error) {
+		if r.file == nil {
+			return nil, nil
+		}
+		return value(r.file), nil
+	}}
+}
+
+// usageFields are the fields of the rows of the disk usage.
+var usageFields = []field[*usageRow]{
+	{"group", func(r *usageRow) (any, error) { return r.group, nil }},
+	{"name", func(r *usageRow) (any, error) { return r.name, nil }},
+	{"entries", func(r *usageRow) (any, error) { return r.entries, nil }},
+	{"header", func(r *usageRow) (any, error) { return r.header, nil }},
+	{"body", func(r *usageRow) (any, error) { return r.body, nil }},
+	{"bytes", func(r *usageRow) (any, error) { return r.bytes, nil }},
+	blockField("blockSize", func(b *cdc.BlockFileUsage) any { return b.BlockSize }),
+	blockField("blocks", func(b *cdc.BlockFileUsage) any { return b.MaxBlocks }),
+	blockField("used", func(b *cdc.BlockFileUsage) any { return b.UsedBlocks }),
+	blockField("empty", func(b *cdc.BlockFileUsage) any { return b.Empty }),
+	blockField("stale", func(b *cdc.BlockFileUsage) any { return b.Stale }),
+	blockField("fragmentation", func(b *cdc.BlockFileUsage) any { return b.Fragmentation }),
+}
+
+func du(args []string) {
+	var sel selection
+	var out output[*usageRow]
+	var top int
+
+	flags := newFlagSet("du")
+	flags.IntVar(&top, "top", 10, "")
+	sel.addFlags(flags, false)
+	out.addFlags(flags, usageFields, "group,name,entries,header,body",
+		"group,name,entries,header,body,bytes,blockSize,blocks,used,empty,stale,fragmentation")
+	cachedir := parseArgs(flags, args, 1)[0]
+
+	cache := openCache(cachedir, sel.snapshot)
+	var query *cdc.Query
+	if !sel.empty() {
+		query = &sel.query
+	}
+	usage, err := cache.UsageQuery(query)
+	_ = cache.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if out.format == "text" && out.fields == nil {
+		err = printReport(usage, top)
+	} else {
+		err = printRows(&out, usage)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// printRows prints the rows of usage with out.
+func printRows(out *output[*usageRow], usage *cdc.Usage) error {
+	stat := func(group, name string, s *cdc.UsageStat) *usageRow {
+		return &usageRow{group: group, name: name, entries: s.Count,
+			header: s.HeaderBytes, body: s.BodyBytes, bytes: s.HeaderBytes + s.BodyBytes}
+	}
+
+	rows := []*usageRow{
+		{group: "total", entries: usage.Total.Count, header: usage.Total.HeaderBytes,
+			body: usage.Total.BodyBytes, bytes: usage.Bytes},
+		{group: "errors", entries: usage.Errors},
+		{group: "index", entries: int(usage.IndexEntries), bytes: int64(usage.IndexBytes)},
+	}
+	for _, group := range []struct {
+		name  string
+		stats map[string]*cdc.UsageStat
+	}{{"host", usage.ByHost}, {"type", usage.ByMIME}, {"file", usage.ByFileType}} {
+		for _, key := range sortStats(group.stats, 0) {
+			rows = append(rows, stat(group.name, key, group.stats[key]))
+		}
+	}
+	for _, s := range usage.ByAge {
+		rows = append(rows, stat("age", s.Label, &s.UsageStat))
+	}
+	for _, b := range usage.BlockFiles {
+		rows = append(rows, &usageRow{group: "block", name: b.Name, entries: int(b.NumEntries), file: &b})
+	}
+
+	err := out.begin(os.Stdout)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err = out.print(row)
+		if err != nil {
+			return err
+		}
+	}
+	return out.end()
+}
+
+// printReport prints usage as a report, with the top largest hosts
+// and MIME types.
+func printReport(usage *cdc.Usage, top int) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "entries\t%d\t(index %d)\n", usage.Total.Count, usage.IndexEntries)
+	fmt.Fprintf(w, "bytes\t%s\t(index %s)\n", formatBytes(usage.Bytes), formatBytes(int64(usage.IndexBytes)))
+	fmt.Fprintf(w, "header\t%s\n", formatBytes(usage.Total.HeaderBytes))
+	fmt.Fprintf(w, "body\t%s\n", formatBytes(usage.Total.BodyBytes))
+	if usage.Errors != 0 {
+		fmt.Fprintf(w, "errors\t%d\n", usage.Errors)
+	}
+
+	printStats(w, "host", usage.ByHost, top)
+	printStats(w, "type", usage.ByMIME, top)
+	printStats(w, "file", usage.ByFileType, 0)
+
+	fmt.Fprint(w, "\nage\tentries\theader\tbody\n")
+	for _, s := range usage.ByAge {
+		printStat(w, s.Label, s.UsageStat)
+	}
+
+	fmt.Fprint(w, "\nblock file\tblock size\tblocks\tused\tallocations\tempty\tfragmentation\n")
+	for _, b := range usage.BlockFiles {
+		empty := fmt.Sprintf("%d,%d,%d,%d", b.Empty[0], b.Empty[1], b.Empty[2], b.Empty[3])
+		if b.Stale {
+			empty += " (stale)"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%.1f%%\n",
+			b.Name, b.BlockSize, b.MaxBlocks, b.UsedBlocks, b.NumEntries, empty, 100*b.Fragmentation)
+	}
+	return w.Flush()
+}
+
+// sortStats returns the keys of the top largest stats,
+// all of them if top is zero.
+func sortStats(stats map[string]*cdc.UsageStat, top int) []string {
+	keys := slices.SortedFunc(maps.Keys(stats), func(a, b string) int {
+		sa, sb := stats[a], stats[b]
+		return cmp.Or(
+			cmp.Compare(sb.HeaderBytes+sb.BodyBytes, sa.HeaderBytes+sa.BodyBytes),
+			strings.Compare(a, b))
+	})
+	if top > 0 && len(keys) > top {
+		keys = keys[:top]
+	}
+	return keys
+}
+
+// printStats prints the top largest stats, all of them if top is zero.
+func printStats(w *tabwriter.Writer, name string, stats map[string]*cdc.UsageStat, top int) {
+	fmt.Fprintf(w, "\n%s\tentries\theader\tbody\n", name)
+	for _, key := range sortStats(stats, top) {
+		if key == "" {
+			printStat(w, "-", *stats[key])
+		} else {
+			printStat(w, key, *stats[key])
+		}
+	}
+}
+
+func printStat(w *tabwriter.Writer, name string, s cdc.UsageStat) {
+	fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", name, s.Count, formatBytes(s.HeaderBytes), formatBytes(s.BodyBytes))
+}
+
+// formatBytes formats n bytes with a binary unit.
+func formatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	size, unit := float64(n)/1024, 0
+	for size >= 1024 && unit < 3 {
+		size /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", size, "KMGT"[unit])
+}
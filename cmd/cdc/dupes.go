@@ -0,0 +1,172 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/schorlet/cdc"
+)
+
+const dupesUsage = `Usage:
+    cdc dupes [flag] CACHEDIR
+
+Dupes groups the entries with identical content, by the SHA-256 hash of
+their decoded body, or of their body as stored if it can not be decoded.
+The groups are listed with the most redundant bytes first, the entries
+with an empty body are ignored.
+
+The entries are selected with:
+` + selectorUsage + `
+In text format, without -fields, the groups are printed with their
+entries, and followed by the redundant bytes. Otherwise, a row is printed
+for each entry of the groups.
+
+The dupes flags are:
+    -raw               hash the body as stored, not decoded
+
+The output flags are:
+    -format string     output format: text, json, jsonl, csv or tsv (default "text")
+    -fields string     comma separated fields to print, among:
+                       hash, entries, groupSize, redundant, addr, url, size
+`
+
+// dupeGroup is a group of entries with identical content.
+type dupeGroup struct {
+	Hash    string
+	Size    int64 // Sum of the body sizes, as stored.
+	Entries []dupeEntry
+}
+
+type dupeEntry struct {
+	Addr cdc.Addr
+	URL  string
+	Size int32
+}
+
+// dupeRow is an entry of a group.
+type dupeRow struct {
+	group *dupeGroup
+	entry dupeEntry
+}
+
+// dupeFields are the fields of the entries of the groups.
+var dupeFields = []field[*dupeRow]{
+	{"hash", func(r *dupeRow) (any, error) { return r.group.Hash, nil }},
+	{"entries", func(r *dupeRow) (any, error) { return len(r.group.Entries), nil }},
+	{"groupSize", func(r *dupeRow) (any, error) { return r.group.Size, nil }},
+	{"redundant", func(r *dupeRow) (any, error) { return r.group.redundant(), nil }},
+	{"addr", func(r *dupeRow) (any, error) { return r.entry.Addr, nil }},
+	{"url", func(r *dupeRow) (any, error) { return r.entry.URL, nil }},
+	{"size", func(r *dupeRow) (any, error) { return r.entry.Size, nil }},
+}
+
+// redundant returns the redundant bytes, stored in addition to the smallest
+// entry.
+func (g *dupeGroup) redundant() int64 {
+	smallest := slices.MinFunc(g.Entries, func(a, b dupeEntry) int {
+		return cmp.Compare(a.Size, b.Size)
+	})
+	return g.Size - int64(smallest.Size)
+}
+
+func dupes(args []string) {
+	var sel selection
+	var out output[*dupeRow]
+	var raw bool
+
+	flags := newFlagSet("dupes")
+	sel.addFlags(flags, false)
+	flags.BoolVar(&raw, "raw", false, "")
+	out.addFlags(flags, dupeFields, "hash,addr,url", "hash,addr,url,size")
+	cachedir := parseArgs(flags, args, 1)[0]
+
+	cache := openCache(cachedir, sel.snapshot)
+	groups := make(map[cdc.Sum]*dupeGroup)
+	ok := true
+	for entry, err := range sel.entries(cache) {
+		if err == nil && (entry.Child() || entry.DataSize[1] == 0) {
+			continue
+		}
+		var hash *cdc.BodyHash
+		if err == nil {
+			hash, err = entry.BodyHash()
+		}
+		if err != nil {
+			log.Print(err)
+			ok = false
+			continue
+		}
+
+		sum := hash.Decoded
+		if raw || hash.DecodeErr != nil {
+			sum = hash.Raw
+		}
+		g, found := groups[sum]
+		if !found {
+			g = &dupeGroup{Hash: sum.String()}
+			groups[sum] = g
+		}
+		g.Size += int64(entry.DataSize[1])
+		g.Entries = append(g.Entries, dupeEntry{Addr: entry.Addr(), URL: entry.URL(), Size: entry.DataSize[1]})
+	}
+	_ = cache.Close()
+
+	maps.DeleteFunc(groups, func(_ cdc.Sum, g *dupeGroup) bool { return len(g.Entries) < 2 })
+	sorted := slices.SortedFunc(maps.Values(groups), func(a, b *dupeGroup) int {
+		return cmp.Or(cmp.Compare(b.redundant(), a.redundant()), cmp.Compare(a.Hash, b.Hash))
+	})
+
+	var err error
+	if out.format == "text" && out.fields == nil {
+		err = printDupes(sorted)
+	} else {
+		err = printDupeRows(&out, sorted)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// printDupeRows prints the entries of the groups with out.
+func printDupeRows(out *output[*dupeRow], groups []*dupeGroup) error {
+	err := out.begin(os.Stdout)
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		for _, e := range g.Entries {
+			err = out.print(&dupeRow{group: g, entry: e})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return out.end()
+}
+
+// printDupes prints the groups with their entries.
+func printDupes(groups []*dupeGroup) error {
+	var redundant int64
+	for i, g := range groups {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s\t%d entries\t%s\n", g.Hash, len(g.Entries), formatBytes(g.Size))
+		for _, e := range g.Entries {
+			fmt.Printf("\t%d\t%s\n", e.Addr, e.URL)
+		}
+		redundant += g.redundant()
+	}
+	if len(groups) > 0 {
+		fmt.Println()
+	}
+	_, err := fmt.Printf("%d groups, %s redundant\n", len(groups), formatBytes(redundant))
+	return err
+}
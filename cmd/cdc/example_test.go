@@ -7,7 +7,9 @@ import (
 	"image/png"
 	"io"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"sort"
 )
 
@@ -103,6 +105,132 @@ func Example_body() {
 	// PNG image data, 83 x 120
 }
 
+func Example_select() {
+	cmd := exec.Command("./cdc", "list", "-match", "*/pkg/*/", "../../testdata", "-host", "golang.org")
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+
+	if err := cmd.Run(); err != nil {
+		log.Fatal(err)
+	}
+
+	lines := read(&output)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+
+	// Output:
+	// 2684420119	https://golang.org/pkg/builtin/
+	// 2684420123	https://golang.org/pkg/bufio/
+	// 2684420134	https://golang.org/pkg/bytes/
+	// 2684420137	https://golang.org/pkg/io/ioutil/
+	// 2684420139	https://golang.org/pkg/os/
+	// 2684420140	https://golang.org/pkg/io/
+	// 2684420145	https://golang.org/pkg/strconv/
+	// 2684420147	https://golang.org/pkg/strings/
+}
+
+func Example_format() {
+	cmd := exec.Command("./cdc", "search", "-url", "treeview",
+		"-format", "jsonl", "-fields", "addr,url,size,created", "../../testdata")
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+
+	if err := cmd.Run(); err != nil {
+		log.Fatal(err)
+	}
+
+	lines := read(&output)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+
+	// Output:
+	// {"addr":2684420100,"url":"https://golang.org/lib/godoc/jquery.treeview.css","size":733,"created":"2016-01-09T22:58:22.293059Z"}
+	// {"addr":2684420103,"url":"https://golang.org/lib/godoc/jquery.treeview.js","size":2534,"created":"2016-01-09T22:58:22.303721Z"}
+	// {"addr":2684420104,"url":"https://golang.org/lib/godoc/jquery.treeview.edit.js","size":598,"created":"2016-01-09T22:58:22.303781Z"}
+}
+
+func Example_diff() {
+	cmd := exec.Command("./cdc", "diff", "-format", "json", "../../testdata", "../../testdata")
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+
+	if err := cmd.Run(); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Print(output.String())
+	// Output:
+	// []
+}
+
+func Example_copy() {
+	dir, err := os.MkdirTemp("", "cdc-copy-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for range 2 {
+		cmd := exec.Command("./cdc", "copy", "-match", "*/pkg/*/", "../../testdata", dir)
+		cmd.Stdout = os.Stdout
+		if err := cmd.Run(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("./cdc", "list", "-fields", "url", dir)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+
+	if err := cmd.Run(); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, line := range read(&output) {
+		fmt.Println(line)
+	}
+	// Output:
+	// 8 copied, 0 kept
+	// 0 copied, 8 kept
+	// https://golang.org/pkg/bufio/
+	// https://golang.org/pkg/builtin/
+	// https://golang.org/pkg/bytes/
+	// https://golang.org/pkg/io/
+	// https://golang.org/pkg/io/ioutil/
+	// https://golang.org/pkg/os/
+	// https://golang.org/pkg/strconv/
+	// https://golang.org/pkg/strings/
+}
+
+func Example_convert() {
+	dir, err := os.MkdirTemp("", "cdc-convert-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	simple := filepath.Join(dir, "simple")
+	cmd := exec.Command("./cdc", "convert", "../../testdata", simple)
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		log.Fatal(err)
+	}
+
+	cmd = exec.Command("./cdc", "convert", "-to", "blockfile", simple, filepath.Join(dir, "blockfile"))
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// 19 entries converted to simple
+	// 19 entries converted to blockfile
+}
+
 func read(r io.Reader) []string {
 	lines := make([]string, 0)
 
@@ -114,3 +242,53 @@ func read(r io.Reader) []string {
 	sort.Strings(lines)
 	return lines
 }
+
+func Example_dupes() {
+	cmd := exec.Command("./cdc", "list", "-match", "*.css", "-fields", "url", "-hash", "../../testdata")
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		log.Fatal(err)
+	}
+
+	cmd = exec.Command("./cdc", "dupes", "-match", "*.css", "../../testdata")
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// https://golang.org/lib/godoc/style.css	6f79e10a478c7fd05176b59ea0b641b8a0455f92671e573a12424485170d7c0b	a8eb34c338c4f815ab9c3e6d6181036b1f58096ca584cb221ce198651c33c6c3
+	// https://golang.org/lib/godoc/jquery.treeview.css	6e8e26d65894cce6320b2c97520f273fa6967db3e58c6dc4f5c1140f994aafeb	4bb5b5b9839141fbbcd5eb40279d8b745eed793e37d557a35b28f0e702c9ee71
+	// 0 groups, 0 B redundant
+}
+
+func Example_hashcheck() {
+	dir, err := os.MkdirTemp("", "cdc-hashcheck-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// decoded style.css in a NSRL list, and jquery.treeview.css as stored
+	known := filepath.Join(dir, "known.csv")
+	err = os.WriteFile(known, []byte(`"SHA-1","MD5","CRC32","FileName"`+"\n"+
+		`"F7007C59580CC97E4C5644A94791387651607605","7E4000B25F99FCA6CAA6C7A9D91F5400","00000000","style.css"`+"\n"), 0o644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.csv")
+	err = os.WriteFile(bad, []byte(`"SHA-256","FileName"`+"\n"+
+		`"6E8E26D65894CCE6320B2C97520F273FA6967DB3E58C6DC4F5C1140F994AAFEB","treeview.css"`+"\n"), 0o644)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cmd := exec.Command("./cdc", "hashcheck", "-known", known, "-bad", bad, "-match", "*.css", "../../testdata")
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// known	decoded	2684420099	https://golang.org/lib/godoc/style.css
+	// bad	raw	2684420100	https://golang.org/lib/godoc/jquery.treeview.css
+	// 1 bad, 1 known, 0 unknown
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/schorlet/cdc"
+)
+
+const hashcheckUsage = `Usage:
+    cdc hashcheck [flag] CACHEDIR
+
+Hashcheck hashes the body of the selected entries, as stored and decoded,
+and tags the entries whose hash is in a list of bad files as "bad", in a
+list of known files as "known", and the other entries as "unknown".
+
+The lists are text or CSV files of MD5, SHA-1 or SHA-256 hashes, like
+the CSV files of the NSRL RDS. Each line is split on commas, semicolons,
+quotes, spaces and tabs, and every value of 32, 40 or 64 hexadecimal
+digits, in any case, is read as an MD5, SHA-1 or SHA-256 hash. The other
+values, like the CRC32 hashes, the header of a CSV file and the lines
+starting with # are ignored. A list without any hash is an error.
+
+The entries are selected with:
+` + selectorUsage + `
+The hashcheck flags are:
+    -known file        list of known hashes (repeatable)
+    -bad file          list of bad hashes (repeatable)
+    -tag string        comma separated tags of the entries printed (default all)
+
+The output flags are:
+    -format string     output format: text, json, jsonl, csv or tsv (default "text")
+    -fields string     comma separated fields to print, among:
+                       tag, match, algorithm, list, addr, url, hash, decodedHash
+
+In text format, the entries are followed by the count of each tag.
+`
+
+// Tags of the entries.
+const (
+	tagBad     = "bad"
+	tagKnown   = "known"
+	tagUnknown = "unknown"
+)
+
+// Hash algorithms of the lists, by length of their hexadecimal digests.
+var algorithms = map[int]string{32: "md5", 40: "sha1", 64: "sha256"}
+
+// hashSet is a set of hexadecimal hashes, in lowercase,
+// with the list holding each hash.
+type hashSet map[string]string
+
+// load adds the MD5, SHA-1 and SHA-256 hashes of the list name to the set.
+func (s hashSet) load(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		values := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ';' || r == '"' || r == '\'' || r == ' ' || r == '\t'
+		})
+		for _, v := range values {
+			if _, ok := algorithms[len(v)]; !ok {
+				continue
+			}
+			if _, err := hex.DecodeString(v); err == nil {
+				s[strings.ToLower(v)] = name
+				n++
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: no MD5, SHA-1 or SHA-256 hash", name)
+	}
+	return nil
+}
+
+// hashRecord is the result of an entry, as printed.
+type hashRecord struct {
+	Tag         string
+	Match       string // Body matching: "raw" or "decoded".
+	Algorithm   string // Hash matching: "md5", "sha1" or "sha256".
+	List        string
+	Addr        cdc.Addr
+	URL         string
+	Hash        string
+	DecodedHash string
+}
+
+// hashFields are the fields of the results.
+var hashFields = []field[*hashRecord]{
+	{"tag", func(r *hashRecord) (any, error) { return r.Tag, nil }},
+	{"match", func(r *hashRecord) (any, error) { return r.Match, nil }},
+	{"algorithm", func(r *hashRecord) (any, error) { return r.Algorithm, nil }},
+	{"list", func(r *hashRecord) (any, error) { return r.List, nil }},
+	{"addr", func(r *hashRecord) (any, error) { return r.Addr, nil }},
+	{"url", func(r *hashRecord) (any, error) { return r.URL, nil }},
+	{"hash", func(r *hashRecord) (any, error) { return r.Hash, nil }},
+	{"decodedHash", func(r *hashRecord) (any, error) { return r.DecodedHash, nil }},
+}
+
+// check tags the entry of hash with the lists of bad and known hashes.
+func check(record *hashRecord, hash *cdc.BodyHash, bad, known hashSet) {
+	record.Tag = tagUnknown
+	record.Hash = hash.Raw.String()
+	if hash.DecodeErr == nil {
+		record.DecodedHash = hash.Decoded.String()
+	}
+
+	type digest struct {
+		match, algorithm string
+		sum              []byte
+	}
+	var digests []digest
+	if hash.DecodeErr == nil {
+		digests = append(digests,
+			digest{"decoded", "sha256", hash.Decoded[:]},
+			digest{"decoded", "sha1", hash.DecodedSHA1[:]},
+			digest{"decoded", "md5", hash.DecodedMD5[:]})
+	}
+	digests = append(digests,
+		digest{"raw", "sha256", hash.Raw[:]},
+		digest{"raw", "sha1", hash.RawSHA1[:]},
+		digest{"raw", "md5", hash.RawMD5[:]})
+
+	for _, list := range []struct {
+		tag string
+		set hashSet
+	}{{tagBad, bad}, {tagKnown, known}} {
+		for _, d := range digests {
+			if name, ok := list.set[hex.EncodeToString(d.sum)]; ok {
+				record.Tag, record.Match, record.Algorithm, record.List = list.tag, d.match, d.algorithm, name
+				return
+			}
+		}
+	}
+}
+
+func hashcheck(args []string) {
+	var sel selection
+	var out output[*hashRecord]
+	var knownLists, badLists []string
+	var tags string
+
+	flags := newFlagSet("hashcheck")
+	sel.addFlags(flags, false)
+	flags.Func("known", "", func(v string) error {
+		knownLists = append(knownLists, v)
+		return nil
+	})
+	flags.Func("bad", "", func(v string) error {
+		badLists = append(badLists, v)
+		return nil
+	})
+	flags.StringVar(&tags, "tag", "", "")
+	out.addFlags(flags, hashFields, "tag,match,addr,url",
+		"tag,match,algorithm,list,addr,url,hash,decodedHash")
+	cachedir := parseArgs(flags, args, 1)[0]
+	if len(knownLists)+len(badLists) == 0 {
+		log.Fatal("hashcheck: no list of hashes, set -known or -bad")
+	}
+	printed := []string{tagBad, tagKnown, tagUnknown}
+	if tags != "" {
+		printed = strings.Split(tags, ",")
+		for _, tag := range printed {
+			if tag != tagBad && tag != tagKnown && tag != tagUnknown {
+				log.Fatalf("unknown tag %q", tag)
+			}
+		}
+	}
+
+	known, bad := make(hashSet), make(hashSet)
+	for _, name := range knownLists {
+		if err := known.load(name); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, name := range badLists {
+		if err := bad.load(name); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	err := out.begin(os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	cache := openCache(cachedir, sel.snapshot)
+	ok := true
+	for entry, err := range sel.entries(cache) {
+		if err == nil && entry.Child() {
+			continue
+		}
+		var hash *cdc.BodyHash
+		if err == nil {
+			hash, err = entry.BodyHash()
+		}
+		if err != nil {
+			log.Print(err)
+			ok = false
+			continue
+		}
+
+		record := hashRecord{Addr: entry.Addr(), URL: entry.URL()}
+		check(&record, hash, bad, known)
+		counts[record.Tag]++
+		if slices.Contains(printed, record.Tag) {
+			if err := out.print(&record); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	_ = cache.Close()
+
+	if err := out.end(); err != nil {
+		log.Fatal(err)
+	}
+	if out.format == "text" {
+		fmt.Printf("%d bad, %d known, %d unknown\n", counts[tagBad], counts[tagKnown], counts[tagUnknown])
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
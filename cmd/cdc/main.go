@@ -1,27 +1,35 @@
 // Command cdc helps reading disk cache from command line.
 //
-//  Usage:
-//	cdc command [flag] CACHEDIR
+//	Usage:
+//		cdc command [flag] CACHEDIR
 //
-// 	The commands are:
+//	The commands are:
 //		list        list entries
-//		header      print entry header
-//		body        print entry body
+//		header      print entry headers
+//		body        print entry bodies
+//		search      search entries
+//		diff        compare two caches
+//		copy        copy entries into another cache
+//		convert     convert a cache to the blockfile or simple format
+//		du          report the disk usage of a cache
+//		dupes       group entries with identical content
+//		hashcheck   match entry bodies against lists of hashes
+//		timeline    list the times of entries in chronological order
+//		audit       report stale, uncacheable and suspicious entries
 //
-//	The flags are:
-//		-url string        entry url
-//		-addr string       entry addr
+//	Run "cdc help command" or "cdc command -h" for the flags of a command.
 //
 //	CACHEDIR is the path to the chromium cache directory.
+//	The flags may follow CACHEDIR.
 package main
 
 import (
 	"flag"
 	"fmt"
-	"io"
+	"iter"
 	"log"
+	"log/slog"
 	"os"
-	"strconv"
 
 	"github.com/schorlet/cdc"
 )
@@ -33,123 +41,198 @@ Usage:
 
 The commands are:
     list        list entries
-    header      print entry header
-    body        print entry body
-
-The flags are:
-    -url string        entry url
-    -addr string       entry addr
+    header      print entry headers
+    body        print entry bodies
+    search      search entries
+    diff        compare two caches
+    copy        copy entries into another cache
+    convert     convert a cache to the blockfile or simple format
+    du          report the disk usage of a cache
+    dupes       group entries with identical content
+    hashcheck   match entry bodies against lists of hashes
+    timeline    list the times of entries in chronological order
+    audit       report stale, uncacheable and suspicious entries
+
+Run "cdc help command" or "cdc command -h" for the flags of a command.
 
 CACHEDIR is the path to the chromium cache directory.
+The flags may follow CACHEDIR.
 `
 
-func main() {
-	log.SetFlags(0)
+const listUsage = `Usage:
+    cdc list [flag] CACHEDIR
 
-	var cmd, url, addr, cachedir string
-	parseArgs(&cmd, &url, &addr, &cachedir)
+List lists the selected entries, all of them by default.
 
-	cache, err := cdc.OpenCache(cachedir)
-	if err != nil {
-		log.Fatal(err)
-	}
+` + selectUsage + `
+` + outputUsage
 
-	if cmd == "list" {
-		for _, url := range cache.URLs() {
-			addr, err := cache.GetAddr(url)
-			if err != nil {
-				log.Printf("address of %s: %v\n", url, err)
-			}
-			fmt.Printf("%d\t%s\n", addr, url)
-		}
+const headerUsage = `Usage:
+    cdc header [flag] CACHEDIR
 
-	} else {
-		entry := openEntry(cache, url, addr, cachedir)
+Header prints the header of the selected entries, with all its values
+in stored order. In text format, the URL of the entries is printed
+before their header, unless one entry is selected with -url or -addr.
 
-		if cmd == "header" {
-			printHeader(entry)
+` + selectUsage + `
+` + outputUsage
 
-		} else if cmd == "body" {
-			printBody(entry)
+const bodyUsage = `Usage:
+    cdc body [flag] CACHEDIR
 
-		} else {
-			log.Fatalf("unknown command: %q", cmd)
-		}
-	}
+Body prints the body of the selected entries, as stored.
+
+` + selectUsage + `
+` + outputUsage
+
+// usages are the usages of the commands, by name.
+var usages = map[string]string{
+	"list":      listUsage,
+	"header":    headerUsage,
+	"body":      bodyUsage,
+	"search":    searchUsage,
+	"diff":      diffUsage,
+	"copy":      copyUsage,
+	"convert":   convertUsage,
+	"du":        duUsage,
+	"dupes":     dupesUsage,
+	"hashcheck": hashcheckUsage,
+	"timeline":  timelineUsage,
+	"audit":     auditUsage,
 }
 
-func parseArgs(cmd, url, addr, cachedir *string) {
-	if len(os.Args) == 1 {
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
 		log.Fatal(usage)
 	}
+	name, args := os.Args[1], os.Args[2:]
+
+	switch name {
+	case "list":
+		printEntries(name, args, "addr,url", dataFields, false)
+	case "header":
+		printEntries(name, args, "url,header", dataFields+",header", true)
+	case "body":
+		printEntries(name, args, "body", dataFields+",body", true)
+	case "search":
+		search(args)
+	case "diff":
+		diff(args)
+	case "copy":
+		copyEntries(args)
+	case "convert":
+		convert(args)
+	case "du":
+		du(args)
+	case "dupes":
+		dupes(args)
+	case "hashcheck":
+		hashcheck(args)
+	case "timeline":
+		timeline(args)
+	case "audit":
+		audit(args)
+
+	case "help", "-h", "-help", "--help":
+		if len(args) == 1 && usages[args[0]] != "" {
+			fmt.Print(usages[args[0]])
+		} else {
+			fmt.Print(usage)
+		}
 
-	// cmd
-	*cmd = os.Args[1]
+	default:
+		log.Fatalf("unknown command: %q\n\n%s", name, usage)
+	}
+}
 
-	// flags
-	flags := flag.NewFlagSet("", flag.ExitOnError)
-	flags.Usage = func() { log.Println(usage) }
+// printEntries prints the entries selected by the flags of the command
+// name. If required, entries must be selected.
+func printEntries(name string, args []string, textFields, dataFields string, required bool) {
+	var sel selection
+	var out entryOutput
 
-	flags.StringVar(url, "url", "", "entry url")
-	flags.StringVar(addr, "addr", "", "entry addr")
+	flags := newFlagSet(name)
+	sel.addFlags(flags, true)
+	out.addFlags(flags, textFields, dataFields)
+	cachedir := parseArgs(flags, args, 1)[0]
 
-	err := flags.Parse(os.Args[2:])
-	if err != nil {
-		log.Fatal(err)
+	if required && sel.empty() {
+		log.Fatalf("%s: select the entries with -url, -addr or the selectors", name)
 	}
-
-	if *cmd != "list" && flags.NFlag() != 1 {
-		log.Fatal(usage)
+	if name == "header" && len(sel.urls)+len(sel.addrs) == 1 {
+		out.textFields = "header"
 	}
 
-	if flags.NArg() != 1 {
-		log.Fatal(usage)
+	cache := openCache(cachedir, sel.snapshot)
+	ok := printAll(&out, sel.entries(cache))
+	_ = cache.Close()
+	if !ok {
+		os.Exit(1)
 	}
-
-	*cachedir = flags.Arg(0)
 }
 
-func openEntry(cache *cdc.Cache, url, addr, dir string) *cdc.Entry {
-	var entry *cdc.Entry
-	var err error
+// printAll prints entries with out, logging the errors.
+// It reports whether all the entries were printed.
+func printAll(out *entryOutput, entries iter.Seq2[*cdc.Entry, error]) bool {
+	err := out.begin(os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if addr != "" {
-		id, era := strconv.ParseUint(addr, 10, 32)
-		if era != nil {
-			log.Fatal(era)
+	ok := true
+	for entry, err := range entries {
+		if err == nil {
+			err = out.print(entry)
+		}
+		if err != nil {
+			log.Print(err)
+			ok = false
 		}
-		entry, err = cdc.OpenEntry(cdc.Addr(id), dir)
-
-	} else if url != "" {
-		entry, err = cache.OpenURL(url)
 	}
 
+	err = out.end()
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	return entry
+	return ok
 }
 
-func printHeader(entry *cdc.Entry) {
-	header, err := entry.Header()
+// openCache opens the cache of dir, or its snapshot,
+// entries are read as needed.
+func openCache(dir string, snapshot bool) *cdc.Cache {
+	opts := cdc.Options{Logger: slog.Default(), Lazy: true, Snapshot: snapshot}
+	cache, err := cdc.OpenCacheWithOptions(dir, &opts)
 	if err != nil {
 		log.Fatal(err)
 	}
-	for key := range header {
-		fmt.Printf("%s: %s\n", key, header.Get(key))
-	}
+	return cache
 }
 
-func printBody(entry *cdc.Entry) {
-	body, err := entry.Body()
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer body.Close()
+// newFlagSet returns the flag set of the command name,
+// printing its usage on error.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() { log.Print(usages[name]) }
+	return flags
+}
 
-	_, err = io.Copy(os.Stdout, body)
-	if err != nil {
-		log.Println(err)
+// parseArgs parses the flags of args, before and after the n
+// cache directories, and returns the directories.
+func parseArgs(flags *flag.FlagSet, args []string, n int) []string {
+	var dirs []string
+	for {
+		_ = flags.Parse(args)
+		if flags.NArg() == 0 {
+			break
+		}
+		dirs = append(dirs, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if len(dirs) != n {
+		flags.Usage()
+		os.Exit(2)
 	}
+	return dirs
 }
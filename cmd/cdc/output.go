@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schorlet/cdc"
+)
+
+const outputUsage = `The output flags are:
+    -format string     output format: text, json, jsonl, csv or tsv (default "text")
+    -fields string     comma separated fields to print, among:
+                       addr, url, status, contentType, contentEncoding, size,
+                       created, lastUsed, lastModified, requestTime,
+                       responseTime, hash, decodedHash, header, body
+    -hash              print the SHA-256 hash of the body, as stored and decoded,
+                       with the other fields
+`
+
+// dataFields are the fields printed by default in the formats other
+// than text.
+const dataFields = "addr,url,status,contentType,size,created,lastUsed"
+
+// record is an entry being printed, its info read as needed.
+type record struct {
+	entry    *cdc.Entry
+	info     *cdc.ResponseInfo
+	rankings *cdc.Rankings
+	hash     *cdc.BodyHash
+}
+
+func (r *record) responseInfo() (*cdc.ResponseInfo, error) {
+	if r.info == nil {
+		info, err := r.entry.ResponseInfo()
+		if err != nil {
+			return nil, err
+		}
+		r.info = info
+	}
+	return r.info, nil
+}
+
+// lastUsed returns the LRU info of the entry, or nil if it could not be read.
+func (r *record) lastUsed() *cdc.Rankings {
+	if r.rankings == nil {
+		r.rankings, _ = r.entry.Rankings()
+	}
+	return r.rankings
+}
+
+func (r *record) bodyHash() (*cdc.BodyHash, error) {
+	if r.hash == nil {
+		hash, err := r.entry.BodyHash()
+		if err != nil {
+			return nil, err
+		}
+		r.hash = hash
+	}
+	return r.hash, nil
+}
+
+// field is a value printed for each record of type T.
+type field[T any] struct {
+	name  string
+	value func(r T) (any, error)
+}
+
+// entryFields are the fields of the entries.
+var entryFields = []field[*record]{
+	{"addr", func(r *record) (any, error) { return r.entry.Addr(), nil }},
+	{"url", func(r *record) (any, error) { return r.entry.URL(), nil }},
+	{"status", func(r *record) (any, error) {
+		info, err := r.responseInfo()
+		if err != nil {
+			return nil, err
+		}
+		return info.StatusCode, nil
+	}},
+	{"contentType", func(r *record) (any, error) {
+		info, err := r.responseInfo()
+		if err != nil {
+			return nil, err
+		}
+		return info.Header.Get("Content-Type"), nil
+	}},
+	{"contentEncoding", func(r *record) (any, error) {
+		info, err := r.responseInfo()
+		if err != nil {
+			return nil, err
+		}
+		return info.Header.Get("Content-Encoding"), nil
+	}},
+	{"size", func(r *record) (any, error) { return r.entry.DataSize[1], nil }},
+	{"created", func(r *record) (any, error) { return r.entry.Created(), nil }},
+	{"lastUsed", func(r *record) (any, error) {
+		if rankings := r.lastUsed(); rankings != nil {
+			return rankings.LastUsed, nil
+		}
+		return time.Time{}, nil
+	}},
+	{"lastModified", func(r *record) (any, error) {
+		if rankings := r.lastUsed(); rankings != nil {
+			return rankings.LastModified, nil
+		}
+		return time.Time{}, nil
+	}},
+	{"requestTime", func(r *record) (any, error) {
+		info, err := r.responseInfo()
+		if err != nil {
+			return nil, err
+		}
+		return info.RequestTime, nil
+	}},
+	{"responseTime", func(r *record) (any, error) {
+		info, err := r.responseInfo()
+		if err != nil {
+			return nil, err
+		}
+		return info.ResponseTime, nil
+	}},
+	{"hash", func(r *record) (any, error) {
+		hash, err := r.bodyHash()
+		if err != nil {
+			return nil, err
+		}
+		return hash.Raw.String(), nil
+	}},
+	{"decodedHash", func(r *record) (any, error) {
+		hash, err := r.bodyHash()
+		if err != nil {
+			return nil, err
+		}
+		if hash.DecodeErr != nil {
+			return "", nil // not decodable
+		}
+		return hash.Decoded.String(), nil
+	}},
+	{"header", func(r *record) (any, error) {
+		info, err := r.responseInfo()
+		if err != nil {
+			return nil, err
+		}
+		return info.HeaderFields, nil
+	}},
+	{"body", func(r *record) (any, error) {
+		body, err := r.entry.Body()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}},
+}
+
+func lookupField[T any](fields []field[T], name string) (field[T], bool) {
+	i := slices.IndexFunc(fields, func(f field[T]) bool { return f.name == name })
+	if i < 0 {
+		return field[T]{}, false
+	}
+	return fields[i], true
+}
+
+// output prints records of type T in a format, with the selected fields.
+type output[T any] struct {
+	format string
+	all    []field[T]
+	fields []field[T]
+
+	// default fields in text format, and in the other formats
+	textFields, dataFields string
+
+	w   io.Writer
+	csv *csv.Writer
+	n   int // records printed
+}
+
+// addFlags adds the -format and -fields flags to flags, the fields being
+// selected among all. The fields default to textFields in text format,
+// and to dataFields in the other formats.
+func (o *output[T]) addFlags(flags *flag.FlagSet, all []field[T], textFields, dataFields string) {
+	flags.StringVar(&o.format, "format", "text", "")
+	flags.Func("fields", "", func(s string) error {
+		o.fields = nil
+		for _, name := range strings.Split(s, ",") {
+			f, ok := lookupField(all, strings.TrimSpace(name))
+			if !ok {
+				return fmt.Errorf("unknown field %q", name)
+			}
+			o.fields = append(o.fields, f)
+		}
+		return nil
+	})
+	o.all = all
+	o.textFields, o.dataFields = textFields, dataFields
+}
+
+// begin checks the format and starts the output to w,
+// once the flags are parsed.
+func (o *output[T]) begin(w io.Writer) error {
+	if err := o.init(); err != nil {
+		return err
+	}
+	return o.start(w)
+}
+
+// init checks the format and selects the default fields,
+// unless set by the -fields flag.
+func (o *output[T]) init() error {
+	switch o.format {
+	case "text", "json", "jsonl", "csv", "tsv":
+	default:
+		return fmt.Errorf("unknown format %q", o.format)
+	}
+	if o.fields == nil {
+		names := o.dataFields
+		if o.format == "text" {
+			names = o.textFields
+		}
+		for _, name := range strings.Split(names, ",") {
+			f, _ := lookupField(o.all, name)
+			o.fields = append(o.fields, f)
+		}
+	}
+	return nil
+}
+
+// start starts the output to w.
+func (o *output[T]) start(w io.Writer) error {
+	o.w = w
+
+	switch o.format {
+	case "csv", "tsv":
+		o.csv = csv.NewWriter(w)
+		if o.format == "tsv" {
+			o.csv.Comma = '\t'
+		}
+		names := make([]string, len(o.fields))
+		for i, f := range o.fields {
+			names[i] = f.name
+		}
+		return o.csv.Write(names)
+	case "json":
+		_, err := io.WriteString(w, "[")
+		return err
+	}
+	return nil
+}
+
+// end ends the output.
+func (o *output[T]) end() error {
+	switch o.format {
+	case "csv", "tsv":
+		o.csv.Flush()
+		return o.csv.Error()
+	case "json":
+		if o.n > 0 {
+			_, err := io.WriteString(o.w, "\n]\n")
+			return err
+		}
+		_, err := io.WriteString(o.w, "]\n")
+		return err
+	}
+	return nil
+}
+
+// print prints the fields of r. Nothing is printed if a field
+// could not be read.
+func (o *output[T]) print(r T) error {
+	values := make([]any, len(o.fields))
+	for i, f := range o.fields {
+		v, err := f.value(r)
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+
+	var err error
+	switch o.format {
+	case "json", "jsonl":
+		err = o.printJSON(values)
+	case "csv", "tsv":
+		row := make([]string, len(values))
+		for i, v := range values {
+			row[i] = formatValue(v, "\n")
+		}
+		err = o.csv.Write(row)
+	default:
+		err = o.printText(values)
+	}
+	o.n++
+	return err
+}
+
+// printJSON prints values as an object, with the fields in order.
+func (o *output[T]) printJSON(values []any) error {
+	var b bytes.Buffer
+	if o.format == "json" {
+		if o.n > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n  ")
+	}
+
+	b.WriteString("{")
+	for i, v := range values {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		switch t := v.(type) {
+		case time.Time:
+			if t.IsZero() {
+				v = nil
+			}
+		case time.Duration:
+			v = t.Seconds()
+		}
+		name, _ := json.Marshal(o.fields[i].name)
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		b.Write(name)
+		b.WriteString(":")
+		b.Write(value)
+	}
+	b.WriteString("}")
+
+	if o.format == "jsonl" {
+		b.WriteString("\n")
+	}
+	_, err := o.w.Write(b.Bytes())
+	return err
+}
+
+// printText prints values separated by tabs. If the last field is the
+// header, its lines follow the other values, and the entries are
+// separated by an empty line.
+func (o *output[T]) printText(values []any) error {
+	header, ok := values[len(values)-1].([]cdc.HeaderField)
+	if !ok {
+		_, err := fmt.Fprintln(o.w, formatRow(values))
+		return err
+	}
+
+	var b bytes.Buffer
+	if o.n > 0 {
+		b.WriteString("\n")
+	}
+	if len(values) > 1 {
+		b.WriteString(formatRow(values[:len(values)-1]))
+		b.WriteString("\n")
+	}
+	for _, f := range header {
+		fmt.Fprintf(&b, "%s: %s\n", f.Name, f.Value)
+	}
+	_, err := o.w.Write(b.Bytes())
+	return err
+}
+
+// formatRow formats values separated by tabs.
+func formatRow(values []any) string {
+	row := make([]string, len(values))
+	for i, v := range values {
+		row[i] = formatValue(v, "; ")
+	}
+	return strings.Join(row, "\t")
+}
+
+// formatValue formats v as text, the header lines separated by sep.
+func formatValue(v any, sep string) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	case []cdc.HeaderField:
+		lines := make([]string, len(v))
+		for i, f := range v {
+			lines[i] = f.Name + ": " + f.Value
+		}
+		return strings.Join(lines, sep)
+	case []byte:
+		return string(v)
+	case []string:
+		return strings.Join(v, ",")
+	case time.Duration:
+		return v.String()
+	case cdc.Addr:
+		return strconv.FormatUint(uint64(v), 10)
+	}
+	return fmt.Sprint(v)
+}
+
+// entryOutput prints the entries, with the -hash flag.
+type entryOutput struct {
+	output[*record]
+	hash bool
+}
+
+// addFlags adds the -format, -fields and -hash flags to flags.
+func (o *entryOutput) addFlags(flags *flag.FlagSet, textFields, dataFields string) {
+	o.output.addFlags(flags, entryFields, textFields, dataFields)
+	flags.BoolVar(&o.hash, "hash", false, "")
+}
+
+// begin checks the format and starts the output to w,
+// once the flags are parsed.
+func (o *entryOutput) begin(w io.Writer) error {
+	if err := o.init(); err != nil {
+		return err
+	}
+	if o.hash {
+		o.fields = slices.DeleteFunc(o.fields, func(f field[*record]) bool {
+			return f.name == "hash" || f.name == "decodedHash"
+		})
+		// before a trailing header or body
+		i := len(o.fields)
+		if i > 0 && (o.fields[i-1].name == "header" || o.fields[i-1].name == "body") {
+			i--
+		}
+		hash, _ := lookupField(entryFields, "hash")
+		decoded, _ := lookupField(entryFields, "decodedHash")
+		o.fields = slices.Insert(o.fields, i, hash, decoded)
+	}
+	return o.start(w)
+}
+
+// print prints the fields of entry.
+func (o *entryOutput) print(entry *cdc.Entry) error {
+	if o.format == "text" && len(o.fields) == 1 && o.fields[0].name == "body" {
+		// streamed as is
+		body, err := entry.Body()
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		_, err = io.Copy(o.w, body)
+		return err
+	}
+	return o.output.print(&record{entry: entry})
+}
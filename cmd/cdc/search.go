@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/textproto"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const searchUsage = `Usage:
+    cdc search [flag] CACHEDIR
+
+Search lists the entries matching all the flags.
+
+The flags are:
+    -url string        url contains string
+    -regexp string     url matches regexp, not with -match
+    -header string     header contains value, like "Content-Type: image/" (repeatable)
+    -status int        response status code
+    -text string       decoded textual body contains string
+` + selectorUsage + `
+` + outputUsage
+
+func search(args []string) {
+	var sel selection
+	var out entryOutput
+	var urlRegexp *regexp.Regexp
+
+	flags := newFlagSet("search")
+	flags.StringVar(&sel.query.URL, "url", "", "")
+	flags.Func("regexp", "", func(v string) (err error) {
+		urlRegexp, err = regexp.Compile(v)
+		return err
+	})
+	flags.Func("header", "", func(v string) error {
+		key, value, ok := strings.Cut(v, ":")
+		if !ok {
+			return fmt.Errorf("want Key: value")
+		}
+		if sel.query.Header == nil {
+			sel.query.Header = make(http.Header)
+		}
+		key = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(key))
+		sel.query.Header.Add(key, strings.TrimSpace(value))
+		return nil
+	})
+	flags.IntVar(&sel.query.Status, "status", 0, "")
+	flags.StringVar(&sel.query.Text, "text", "", "")
+	sel.addFlags(flags, false)
+	out.addFlags(flags, "addr,url", dataFields)
+	cachedir := parseArgs(flags, args, 1)[0]
+
+	// both set the regexp of the query
+	if urlRegexp != nil {
+		if sel.query.URLRegexp != nil {
+			log.Fatal("search: -regexp and -match are exclusive")
+		}
+		sel.query.URLRegexp = urlRegexp
+	}
+
+	cache := openCache(cachedir, sel.snapshot)
+	ok := printAll(&out, sel.entries(cache))
+	_ = cache.Close()
+	if !ok {
+		os.Exit(1)
+	}
+}
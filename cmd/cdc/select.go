@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"iter"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schorlet/cdc"
+)
+
+const selectUsage = `The entries are selected with:
+    -url string        entry url (repeatable)
+    -addr string       entry addr (repeatable)
+` + selectorUsage
+
+// selectorUsage is the usage of the selectors common to all the commands.
+const selectorUsage = `    -host string       url host, or one of its subdomains
+    -match pattern     url matches the glob pattern, like "*.png", or the /regexp/
+    -type string       Content-Type contains string, like image/
+    -min-size int      minimum body size
+    -max-size int      maximum body size
+    -since time        created at or after time, like 2016-01-09 or 2016-01-09T22:58:22Z
+    -until time        created before time
+    -snapshot          read a consistent copy of the cache of a running browser
+`
+
+// selection selects entries of the cache, explicitly by URL or address,
+// and with a query.
+type selection struct {
+	urls     []string
+	addrs    []cdc.Addr
+	query    cdc.Query
+	snapshot bool
+}
+
+// addFlags adds the selector flags to flags, and the -url and -addr
+// flags if entries.
+func (s *selection) addFlags(flags *flag.FlagSet, entries bool) {
+	if entries {
+		flags.Func("url", "", func(v string) error {
+			s.urls = append(s.urls, v)
+			return nil
+		})
+		flags.Func("addr", "", func(v string) error {
+			addr, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return err
+			}
+			s.addrs = append(s.addrs, cdc.Addr(addr))
+			return nil
+		})
+	}
+
+	flags.StringVar(&s.query.Host, "host", "", "")
+	flags.Func("match", "", func(v string) (err error) {
+		s.query.URLRegexp, err = compileMatch(v)
+		return err
+	})
+	flags.Func("type", "", func(v string) error {
+		if s.query.Header == nil {
+			s.query.Header = make(http.Header)
+		}
+		s.query.Header.Add("Content-Type", v)
+		return nil
+	})
+	flags.Int64Var(&s.query.MinSize, "min-size", 0, "")
+	flags.Int64Var(&s.query.MaxSize, "max-size", 0, "")
+	flags.Func("since", "", func(v string) (err error) {
+		s.query.Since, err = parseTime(v)
+		return err
+	})
+	flags.Func("until", "", func(v string) (err error) {
+		s.query.Until, err = parseTime(v)
+		return err
+	})
+	flags.BoolVar(&s.snapshot, "snapshot", false, "")
+}
+
+// empty reports whether no entries are selected.
+func (s *selection) empty() bool {
+	q := s.query
+	return len(s.urls) == 0 && len(s.addrs) == 0 &&
+		q.URL == "" && q.URLRegexp == nil && q.Host == "" && len(q.Header) == 0 &&
+		q.Status == 0 && q.MinSize == 0 && q.MaxSize == 0 &&
+		q.Since.IsZero() && q.Until.IsZero() && q.Text == ""
+}
+
+// entries returns an iterator over the selected entries of cache:
+// the entries of the URLs and addresses matching the query if any,
+// all the entries matching the query otherwise.
+func (s *selection) entries(cache *cdc.Cache) iter.Seq2[*cdc.Entry, error] {
+	if len(s.urls) == 0 && len(s.addrs) == 0 {
+		return cache.Search(&s.query)
+	}
+
+	return func(yield func(*cdc.Entry, error) bool) {
+		open := func(entry *cdc.Entry, err error) bool {
+			if err == nil {
+				var ok bool
+				if ok, err = s.query.Match(entry); err == nil && !ok {
+					return true
+				}
+			}
+			if err != nil {
+				return yield(nil, err)
+			}
+			return yield(entry, nil)
+		}
+
+		for _, url := range s.urls {
+			if !open(cache.OpenURL(url)) {
+				return
+			}
+		}
+		for _, addr := range s.addrs {
+			if !open(cache.OpenEntry(addr)) {
+				return
+			}
+		}
+	}
+}
+
+// compileMatch compiles a pattern between slashes as a regexp,
+// and any other pattern as a glob matching the whole URL,
+// where * matches any sequence of characters and ? any character.
+func compileMatch(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return regexp.Compile(pattern[1 : len(pattern)-1])
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// parseTime parses a date or a RFC 3339 time.
+// The zero time is returned for an empty string.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want a date or a RFC 3339 time")
+	}
+	return t, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/schorlet/cdc"
+)
+
+const timelineUsage = `Usage:
+    cdc timeline [flag] CACHEDIR
+
+Timeline lists in chronological order the times of the selected entries,
+one event per source:
+    created              creation of the entry
+    lastUsed             last use, from the rankings
+    lastModified         last modification, from the rankings
+    requestTime          request of the response
+    responseTime         reception of the response
+    header.date          Date header
+    header.lastModified  Last-Modified header
+    header.expires       Expires header
+
+In bodyfile format, the input of mactime, the events are lines named
+after the URL and the source of the event, the time being the access time
+of lastUsed, the modification time of lastModified, the creation time of
+created and the change time of the other sources.
+
+The entries are selected with:
+` + selectorUsage + `
+The timeline flags are:
+    -format string     output format: text, bodyfile, csv or jsonl (default "text")
+    -tz string         time zone of the times, like Local or Europe/Paris (default "UTC")
+`
+
+// timeFormat formats the times of the events, to the microsecond
+// as stored by Chromium.
+const timeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// event is a time of an entry, as printed.
+type event struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Addr   cdc.Addr  `json:"addr"`
+	URL    string    `json:"url"`
+	Size   int32     `json:"size"`
+}
+
+func timeline(args []string) {
+	var sel selection
+	var format, tz string
+
+	flags := newFlagSet("timeline")
+	sel.addFlags(flags, false)
+	flags.StringVar(&format, "format", "text", "")
+	flags.StringVar(&tz, "tz", "UTC", "")
+	cachedir := parseArgs(flags, args, 1)[0]
+
+	switch format {
+	case "text", "bodyfile", "csv", "jsonl":
+	default:
+		log.Fatalf("unknown format %q", format)
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var events []event
+	cache := openCache(cachedir, sel.snapshot)
+	ok := true
+	for entry, err := range sel.entries(cache) {
+		var ts []cdc.Timestamp
+		if err == nil {
+			ts, err = entry.Timestamps()
+		}
+		if err != nil {
+			log.Print(err)
+			ok = false
+			continue
+		}
+		for _, t := range ts {
+			events = append(events, event{
+				Time: t.Time.In(loc), Source: t.Source,
+				Addr: entry.Addr(), URL: entry.URL(), Size: entry.DataSize[1],
+			})
+		}
+	}
+	_ = cache.Close()
+
+	slices.SortStableFunc(events, func(a, b event) int {
+		return cmp.Or(a.Time.Compare(b.Time), cmp.Compare(a.Addr, b.Addr))
+	})
+	err = printEvents(events, format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// printEvents prints the events in format.
+func printEvents(events []event, format string) error {
+	switch format {
+	case "jsonl":
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range events {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		_ = w.Write([]string{"time", "source", "addr", "url", "size"})
+		for _, e := range events {
+			_ = w.Write([]string{e.Time.Format(timeFormat), e.Source,
+				fmt.Sprint(e.Addr), e.URL, fmt.Sprint(e.Size)})
+		}
+		w.Flush()
+		return w.Error()
+
+	case "bodyfile":
+		for _, e := range events {
+			if _, err := fmt.Println(bodyfileLine(e)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, e := range events {
+		_, err := fmt.Printf("%s\t%s\t%d\t%s\n", e.Time.Format(timeFormat), e.Source, e.Addr, e.URL)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bodyfileLine formats e as a line of a bodyfile 3.x:
+// MD5|name|inode|mode|UID|GID|size|atime|mtime|ctime|crtime
+func bodyfileLine(e event) string {
+	var atime, mtime, ctime, crtime int64
+	switch t := e.Time.Unix(); e.Source {
+	case cdc.SourceLastUsed:
+		atime = t
+	case cdc.SourceLastModified:
+		mtime = t
+	case cdc.SourceCreated:
+		crtime = t
+	default:
+		ctime = t
+	}
+	name := strings.ReplaceAll(e.URL, "|", "%7C") + " (" + e.Source + ")"
+	return fmt.Sprintf("0|%s|%d|0|0|0|%d|%d|%d|%d|%d",
+		name, e.Addr, e.Size, atime, mtime, ctime, crtime)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schorlet/cdc"
+)
+
+// Default and maximum number of entries of a page.
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// apiError is the error object of the API.
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// apiHost is a host of the cache with its number of entries.
+type apiHost struct {
+	Host    string `json:"host"`
+	Entries int    `json:"entries"`
+}
+
+// apiPage is a page of entries.
+type apiPage struct {
+	Total   int        `json:"total"`
+	Offset  int        `json:"offset"`
+	Limit   int        `json:"limit"`
+	Entries []apiEntry `json:"entries"`
+}
+
+// apiEntry is the metadata of an entry.
+type apiEntry struct {
+	Addr        cdc.Addr  `json:"addr"`
+	URL         string    `json:"url"`
+	Created     time.Time `json:"created"`
+	Size        int32     `json:"size"` // body size
+	Status      int       `json:"status,omitempty"`
+	ContentType string    `json:"contentType,omitempty"`
+	Error       string    `json:"error,omitempty"` // open or response info error
+}
+
+// apiEntryInfo is the metadata, the response info and the streams of an entry.
+type apiEntryInfo struct {
+	apiEntry
+	File         apiAddrInfo `json:"file"`
+	LastUsed     time.Time   `json:"lastUsed,omitzero"`
+	LastModified time.Time   `json:"lastModified,omitzero"`
+	StatusLine   string      `json:"statusLine,omitempty"`
+	RequestTime  time.Time   `json:"requestTime,omitzero"`
+	ResponseTime time.Time   `json:"responseTime,omitzero"`
+	Header       http.Header `json:"header,omitempty"`
+	Streams      []apiStream `json:"streams"`
+}
+
+// apiAddrInfo is the decoded address of an entry or a stream.
+type apiAddrInfo struct {
+	Name       string `json:"name,omitempty"`
+	Type       string `json:"type"`
+	StartBlock uint32 `json:"startBlock,omitempty"`
+	NumBlocks  uint32 `json:"numBlocks,omitempty"`
+	Offset     int64  `json:"offset,omitempty"`
+}
+
+// apiStream is a data stream of an entry.
+type apiStream struct {
+	Index int         `json:"index"`
+	Size  int32       `json:"size"`
+	Addr  cdc.Addr    `json:"addr"`
+	File  apiAddrInfo `json:"file"`
+}
+
+// newAPI returns the handler of the JSON API of h:
+//
+//	GET /api/hosts                          hosts and their number of entries
+//	GET /api/entries?host=&offset=&limit=   page of entries, of all hosts by default
+//	GET /api/entries/{addr}                 entry metadata, response info and streams
+//	GET /api/entries/{addr}/streams/{index} raw stream data
+//	GET /api/lookup?url=                    entry of url, like /api/entries/{addr}
+//
+// Errors are answered with an apiError.
+func newAPI(h *cacheHandler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/hosts", h.apiHosts)
+	mux.HandleFunc("GET /api/entries", h.apiEntries)
+	mux.HandleFunc("GET /api/entries/{addr}", h.apiEntry)
+	mux.HandleFunc("GET /api/entries/{addr}/streams/{index}", h.apiStream)
+	mux.HandleFunc("GET /api/lookup", h.apiLookup)
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, errors.New("cdc: unknown resource"))
+	})
+	return mux
+}
+
+func (h *cacheHandler) apiHosts(w http.ResponseWriter, r *http.Request) {
+	_, hostURLs := h.hosts()
+	hosts := make([]apiHost, 0, len(hostURLs))
+	for host, urls := range hostURLs {
+		hosts = append(hosts, apiHost{Host: host, Entries: len(urls)})
+	}
+	slices.SortFunc(hosts, func(a, b apiHost) int {
+		return strings.Compare(a.Host, b.Host)
+	})
+	writeJSON(w, hosts)
+}
+
+func (h *cacheHandler) apiEntries(w http.ResponseWriter, r *http.Request) {
+	offset, err := formInt(r, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := formInt(r, "limit", defaultLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit = min(max(limit, 1), maxLimit)
+
+	urls := h.URLs()
+	if host := r.FormValue("host"); host != "" {
+		_, hostURLs := h.hosts()
+		urls = hostURLs[host]
+	}
+
+	page := apiPage{
+		Total:   len(urls),
+		Offset:  offset,
+		Limit:   limit,
+		Entries: []apiEntry{},
+	}
+	start := min(offset, len(urls))
+	end := min(start+limit, len(urls))
+
+	for _, url := range urls[start:end] {
+		entry, err := h.OpenURL(url)
+		if err != nil {
+			page.Entries = append(page.Entries, apiEntry{URL: url, Error: err.Error()})
+			continue
+		}
+		info, err := entry.ResponseInfo()
+		page.Entries = append(page.Entries, newAPIEntry(entry, info, err))
+	}
+	writeJSON(w, page)
+}
+
+func (h *cacheHandler) apiEntry(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.apiOpenEntry(w, r)
+	if ok {
+		writeJSON(w, newAPIEntryInfo(entry))
+	}
+}
+
+func (h *cacheHandler) apiLookup(w http.ResponseWriter, r *http.Request) {
+	entry, err := h.OpenURL(r.FormValue("url"))
+	if err != nil {
+		writeCacheError(w, err)
+		return
+	}
+	writeJSON(w, newAPIEntryInfo(entry))
+}
+
+func (h *cacheHandler) apiStream(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.apiOpenEntry(w, r)
+	if !ok {
+		return
+	}
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	stream, err := entry.Stream(index)
+	if err != nil {
+		writeCacheError(w, err)
+		return
+	}
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", time.Time{}, stream)
+}
+
+// apiOpenEntry opens the entry of the addr path value.
+// It answers the error and returns false if the entry can not be opened.
+func (h *cacheHandler) apiOpenEntry(w http.ResponseWriter, r *http.Request) (*cdc.Entry, bool) {
+	addr, err := strconv.ParseUint(r.PathValue("addr"), 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	entry, err := h.OpenEntry(cdc.Addr(addr))
+	if err != nil {
+		writeCacheError(w, err)
+		return nil, false
+	}
+	return entry, true
+}
+
+// newAPIEntry returns the metadata of entry, with its response info
+// or the error returned reading it.
+func newAPIEntry(entry *cdc.Entry, info *cdc.ResponseInfo, err error) apiEntry {
+	e := apiEntry{
+		Addr:    entry.Addr(),
+		URL:     entry.URL(),
+		Created: entry.Created(),
+		Size:    entry.DataSize[1],
+	}
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+	e.Status = info.StatusCode
+	e.ContentType = info.Header.Get("Content-Type")
+	return e
+}
+
+// newAPIEntryInfo returns the metadata, the response info and the streams of entry.
+func newAPIEntryInfo(entry *cdc.Entry) apiEntryInfo {
+	info, err := entry.ResponseInfo()
+
+	e := apiEntryInfo{
+		apiEntry: newAPIEntry(entry, info, err),
+		File:     newAPIAddrInfo(entry.Addr()),
+	}
+	if err == nil {
+		e.StatusLine = info.StatusLine
+		e.RequestTime = info.RequestTime
+		e.ResponseTime = info.ResponseTime
+		e.Header = info.Header
+	}
+
+	if rankings, err := entry.Rankings(); err == nil {
+		e.LastUsed = rankings.LastUsed
+		e.LastModified = rankings.LastModified
+	}
+
+	for i, addr := range entry.DataAddr {
+		e.Streams = append(e.Streams, apiStream{
+			Index: i,
+			Size:  entry.DataSize[i],
+			Addr:  addr,
+			File:  newAPIAddrInfo(addr),
+		})
+	}
+	return e
+}
+
+func newAPIAddrInfo(addr cdc.Addr) apiAddrInfo {
+	info := addr.Info()
+	return apiAddrInfo{
+		Name:       info.FileName,
+		Type:       info.FileType,
+		StartBlock: info.StartBlock,
+		NumBlocks:  info.NumBlocks,
+		Offset:     info.Offset,
+	}
+}
+
+// formInt returns the integer form value of key, or def if it is empty.
+func formInt(r *http.Request, key string, def int) (int, error) {
+	value := r.FormValue(key)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err == nil && n < 0 {
+		err = errors.New("cdc: negative " + key)
+	}
+	return n, err
+}
+
+// writeJSON answers v as JSON.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("api: %v", err)
+	}
+}
+
+// writeError answers err as an apiError with status.
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(apiError{Status: status, Message: err.Error()})
+}
+
+// writeCacheError answers an error returned by the cache: 404 for the
+// entries not found, the invalid entry addresses and stream indexes,
+// 500 otherwise.
+func writeCacheError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var entryErr *cdc.EntryError
+	if errors.Is(err, cdc.ErrNotFound) {
+		status = http.StatusNotFound
+	} else if errors.As(err, &entryErr) && errors.Is(err, cdc.ErrInvalidAddr) &&
+		(entryErr.Op == "open" || entryErr.Op == "stream") {
+		status = http.StatusNotFound
+	}
+	writeError(w, status, err)
+}
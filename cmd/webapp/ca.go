@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// certAuthority signs the certificates of the hosts
+// intercepted by the proxy.
+type certAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate // [host]certificate
+}
+
+// loadCertAuthority loads the certificate authority from the PEM files
+// certFile and keyFile. The files are created with a new certificate
+// authority if both do not exist, it is an error if only one exists.
+func loadCertAuthority(certFile, keyFile string) (*certAuthority, error) {
+	_, err := os.Stat(certFile)
+	certMissing := errors.Is(err, os.ErrNotExist)
+	_, err = os.Stat(keyFile)
+	keyMissing := errors.Is(err, os.ErrNotExist)
+
+	switch {
+	case certMissing && keyMissing:
+		ca, err := newCertAuthority()
+		if err != nil {
+			return nil, err
+		}
+		return ca, ca.save(certFile, keyFile)
+	case certMissing:
+		return nil, fmt.Errorf("load CA: %s not found, with the key %s", certFile, keyFile)
+	case keyMissing:
+		return nil, fmt.Errorf("load CA: %s not found, with the certificate %s", keyFile, certFile)
+	}
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load CA: %w", err)
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("load CA: %s: not an ECDSA key", keyFile)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("load CA: %w", err)
+	}
+
+	ca := certAuthority{
+		cert:  cert,
+		key:   key,
+		certs: make(map[string]*tls.Certificate),
+	}
+	return &ca, nil
+}
+
+// newCertAuthority generates a new certificate authority.
+func newCertAuthority() (*certAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate CA: %w", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{Organization: []string{"cdc"}, CommonName: "cdc proxy CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("generate CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("generate CA: %w", err)
+	}
+
+	ca := certAuthority{
+		cert:  cert,
+		key:   key,
+		certs: make(map[string]*tls.Certificate),
+	}
+	return &ca, nil
+}
+
+// save writes the certificate and the key of the authority as PEM files.
+func (ca *certAuthority) save(certFile, keyFile string) error {
+	der, err := x509.MarshalECPrivateKey(ca.key)
+	if err != nil {
+		return fmt.Errorf("save CA: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	err = os.WriteFile(certFile, certPEM, 0644)
+	if err != nil {
+		return fmt.Errorf("save CA: %w", err)
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	err = os.WriteFile(keyFile, keyPEM, 0600)
+	if err != nil {
+		return fmt.Errorf("save CA: %w", err)
+	}
+	return nil
+}
+
+// certificate returns a certificate for host, signed by the authority.
+func (ca *certAuthority) certificate(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if cert, ok := ca.certs[host]; ok {
+		return cert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s: %w", host, err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{Organization: []string{"cdc"}, CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s: %w", host, err)
+	}
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+	}
+	ca.certs[host] = &cert
+	return &cert, nil
+}
+
+func serialNumber() *big.Int {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return n
+}
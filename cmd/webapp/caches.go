@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/schorlet/cdc"
+)
+
+// searchHit is an entry found by a search of all the caches.
+type searchHit struct {
+	Cache string
+	URL   string
+}
+
+type cachesHandler struct {
+	names    []string                 // sorted names
+	handlers map[string]*cacheHandler // [name]handler
+}
+
+// CachesHandler returns a handler that serves HTTP requests with the
+// contents of several caches, each one under the path "/NAME/".
+// The home page lists the caches and searches all of them.
+func CachesHandler(caches map[string]*cdc.Cache) http.Handler {
+	handler := cachesHandler{
+		handlers: make(map[string]*cacheHandler),
+	}
+	for name, cache := range caches {
+		handler.names = append(handler.names, name)
+		handler.handlers[name] = newCacheHandler(cache)
+	}
+	slices.Sort(handler.names)
+	return &handler
+}
+
+// ServeHTTP responds to an HTTP request.
+func (h *cachesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, _, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if handler, ok := h.handlers[name]; ok {
+		if !found {
+			// relative to the request, which may be under a base path
+			w.Header().Set("Location", url.PathEscape(name)+"/")
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		http.StripPrefix("/"+name, handler).ServeHTTP(w, r)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, staticPath) {
+		staticHandler.ServeHTTP(w, r)
+		return
+	}
+	if r.URL.Path != "/" {
+		// assets requested from a page of a cache
+		if handler := h.refererHandler(r); handler != nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		log.Println(r.URL)
+		http.Error(w, "cdc: unknown resource", http.StatusBadRequest)
+		return
+	}
+
+	log.Println(r.URL)
+	_ = r.ParseForm()
+	if r.Form.Has("q") {
+		h.handleSearch(w, r)
+		return
+	}
+	renderIndex(w, &indexData{Caches: h.names})
+}
+
+// refererHandler returns the handler of the cache of the referer, or nil.
+func (h *cachesHandler) refererHandler(r *http.Request) *cacheHandler {
+	referer, err := url.Parse(r.Referer())
+	if err != nil || referer.Host != r.Host {
+		return nil
+	}
+	// the base path stripped from the request
+	requestURL, err := url.ParseRequestURI(r.RequestURI)
+	if err != nil {
+		return nil
+	}
+	base := strings.TrimSuffix(requestURL.Path, r.URL.Path)
+
+	path, ok := strings.CutPrefix(referer.Path, base+"/")
+	if !ok {
+		return nil
+	}
+	name, _, _ := strings.Cut(path, "/")
+	return h.handlers[name]
+}
+
+// handleSearch prints the entries of all the caches matching the search form.
+func (h *cachesHandler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query, err := parseQuery(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data := indexData{Caches: h.names, Search: true, Form: r.Form}
+	for _, name := range h.names {
+		for entry, err := range h.handlers[name].Search(query) {
+			if err != nil {
+				log.Printf("%s: %v", name, err)
+				continue
+			}
+			data.Hits = append(data.Hits, searchHit{Cache: name, URL: entry.URL()})
+		}
+	}
+	renderIndex(w, &data)
+}
+
+// parseCacheArg returns the name and the directory of a cache argument,
+// "NAME=DIR" or "DIR" named after its base name.
+func parseCacheArg(arg string) (name, dir string, err error) {
+	name, dir, found := strings.Cut(arg, "=")
+	if !found {
+		dir = arg
+		name = filepath.Base(filepath.Clean(dir))
+	}
+	if name == "" || name == "." || strings.ContainsAny(name, "/\\?#%") {
+		return "", "", fmt.Errorf("invalid cache name %q", name)
+	}
+	return name, dir, nil
+}
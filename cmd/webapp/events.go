@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/schorlet/cdc"
+)
+
+// eventsPath is the path of the Server-Sent Events of the changes of a cache.
+const eventsPath = staticPath + "events"
+
+// apiEvent is a change of an entry, as sent to the clients.
+type apiEvent struct {
+	Type string   `json:"type"`
+	Addr cdc.Addr `json:"addr"`
+	URL  string   `json:"url"`
+}
+
+// broker sends the events to its subscribers.
+type broker struct {
+	mu   sync.Mutex
+	subs map[chan cdc.Event]struct{}
+}
+
+func newBroker() *broker {
+	return &broker{subs: make(map[chan cdc.Event]struct{})}
+}
+
+// subscribe returns a channel receiving the next events.
+func (b *broker) subscribe() chan cdc.Event {
+	ch := make(chan cdc.Event, 64)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[ch] = struct{}{}
+	return ch
+}
+
+func (b *broker) unsubscribe(ch chan cdc.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, ch)
+}
+
+// publish sends event to the subscribers,
+// dropping it for the subscribers not keeping up.
+func (b *broker) publish(event cdc.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// watch reloads the cache every interval until ctx is done,
+// updating the hosts and publishing the changes to the events clients.
+// The hosts are updated once per reload, before its changes are published.
+// It must be called before serving requests.
+func (h *cacheHandler) watch(ctx context.Context, interval time.Duration) {
+	h.events = newBroker()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			changes, err := h.Reload()
+			if err != nil {
+				log.Printf("watch: %v", err)
+				continue
+			}
+			if slices.ContainsFunc(changes, func(e cdc.Event) bool {
+				return e.Type != cdc.EntryUpdated
+			}) {
+				h.reindex()
+			}
+			for _, event := range changes {
+				h.events.publish(event)
+			}
+		}
+	}()
+}
+
+// watchHandler watches the caches served by handler, see cacheHandler.watch.
+func watchHandler(ctx context.Context, handler http.Handler, interval time.Duration) {
+	switch h := handler.(type) {
+	case *cacheHandler:
+		h.watch(ctx, interval)
+	case *cachesHandler:
+		for _, name := range h.names {
+			h.handlers[name].watch(ctx, interval)
+		}
+	}
+}
+
+// handleEvents streams the changes of the cache as Server-Sent Events,
+// named after their type: "added", "removed" or "updated".
+func (h *cacheHandler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		http.Error(w, "cdc: cache not watched", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "cdc: streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events := h.events.subscribe()
+	defer h.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = fmt.Fprint(w, ": cdc events\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			data, err := json.Marshal(apiEvent{
+				Type: event.Type.String(),
+				Addr: event.Addr,
+				URL:  event.URL,
+			})
+			if err != nil {
+				log.Printf("events: %v", err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
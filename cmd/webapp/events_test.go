@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schorlet/cdc"
+)
+
+// copyCache copies the files of the test cache into a temporary directory.
+func copyCache(t *testing.T) string {
+	dir := t.TempDir()
+	files, err := os.ReadDir("../../testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		b, err := os.ReadFile(filepath.Join("../../testdata", file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(dir, file.Name()), b, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestEvents(t *testing.T) {
+	dir := copyCache(t)
+	cache, err := cdc.OpenCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	jquery := "https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js"
+	entry, err := cache.OpenURL(jquery)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := newCacheHandler(cache)
+	handler.watch(ctx, 10*time.Millisecond)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := http.Client{Timeout: 5 * time.Second}
+	res, err := client.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `<script src="_cdc/live.js">`) {
+		t.Fatal("live view not found")
+	}
+
+	res, err = client.Get(server.URL + eventsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("bad content-type: %q", ct)
+	}
+	lines := bufio.NewScanner(res.Body)
+	if !lines.Scan() || lines.Text() != ": cdc events" || !lines.Scan() {
+		t.Fatalf("bad stream start: %q", lines.Text())
+	}
+
+	// bump the ReuseCount of the entry
+	info := entry.Addr().Info()
+	name := filepath.Join(dir, info.FileName)
+	file, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reuse := binary.LittleEndian.AppendUint32(nil, uint32(entry.ReuseCount+1))
+	_, err = file.WriteAt(reuse, info.Offset+12)
+	_ = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(time.Second)
+	err = os.Chtimes(name, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var event []string
+	for lines.Scan() && lines.Text() != "" {
+		event = append(event, lines.Text())
+	}
+	if len(event) != 2 || event[0] != "event: updated" ||
+		!strings.Contains(event[1], `"url":"`+jquery+`"`) {
+		t.Fatalf("bad event: %q", event)
+	}
+}
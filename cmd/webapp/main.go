@@ -2,18 +2,36 @@
 package main
 
 import (
-	"html/template"
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/schorlet/cdc"
 )
 
 type cacheHandler struct {
 	*cdc.Cache
+	api    http.Handler // JSON API under /api/
+	events *broker      // changes of the cache, if watched
+
+	mu   sync.RWMutex        // guards host and url, replaced on changes
 	host map[string]bool     // [hostname]bool
 	url  map[string][]string // [hostname]urls
 }
@@ -21,34 +39,74 @@ type cacheHandler struct {
 // CacheHandler returns a handler that serves HTTP requests
 // with the contents of the specified cache.
 func CacheHandler(cache *cdc.Cache) http.Handler {
-	handler := cacheHandler{
-		Cache: cache,
-		host:  make(map[string]bool),
-		url:   make(map[string][]string),
-	}
+	return newCacheHandler(cache)
+}
+
+func newCacheHandler(cache *cdc.Cache) *cacheHandler {
+	handler := cacheHandler{Cache: cache}
+	handler.api = newAPI(&handler)
+	handler.reindex()
+	return &handler
+}
 
-	for _, ustr := range cache.URLs() {
+// reindex groups the URLs of the cache by host.
+func (h *cacheHandler) reindex() {
+	hosts := make(map[string]bool)
+	urls := make(map[string][]string)
+
+	for _, ustr := range h.URLs() {
 		u, err := url.Parse(ustr)
 		if err != nil {
 			continue
 		}
 		if len(u.Host) != 0 {
-			if !handler.host[u.Host] {
-				handler.host[u.Host] = true
+			if !hosts[u.Host] {
+				hosts[u.Host] = true
 			}
-			handler.url[u.Host] = append(handler.url[u.Host], ustr)
+			urls[u.Host] = append(urls[u.Host], ustr)
 		}
 	}
-	return &handler
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.host, h.url = hosts, urls
+}
+
+// hosts returns the hosts of the cache and their URLs.
+func (h *cacheHandler) hosts() (map[string]bool, map[string][]string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.host, h.url
 }
 
 // ServeHTTP responds to an HTTP request.
 func (h *cacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Println(r.URL)
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		h.api.ServeHTTP(w, r)
+		return
+	}
+	if r.URL.Path == eventsPath {
+		h.handleEvents(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, staticPath) {
+		staticHandler.ServeHTTP(w, r)
+		return
+	}
+
+	_ = r.ParseForm()
 	host := r.FormValue("host")
 	view := r.FormValue("view")
+	inspect := r.FormValue("inspect")
+
+	if r.Form.Has("q") {
+		h.handleSearch(w, r)
 
-	if len(host) != 0 {
+	} else if len(inspect) != 0 {
+		h.handleInspect(w, r, inspect)
+
+	} else if len(host) != 0 {
 		h.handleHost(w, r, host)
 
 	} else if len(view) != 0 {
@@ -69,30 +127,126 @@ func (h *cacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// indexData is the data of the index template.
+type indexData struct {
+	Hosts map[string]bool
+	URLs  []string
+
+	Caches []string    // names of the caches
+	Hits   []searchHit // search results of all the caches
+
+	Live bool // the changes of the cache are pushed
+
+	Search bool       // URLs or Hits are search results
+	Form   url.Values // search form values
+}
+
 // handleHost prints all hosts or all URLs from host.
 func (h *cacheHandler) handleHost(w http.ResponseWriter, r *http.Request, host string) {
-	t, err := template.ParseFiles("index.html")
+	data := indexData{Live: h.events != nil}
+	hosts, urls := h.hosts()
+
+	if len(host) == 0 {
+		data.Hosts = hosts
+	} else {
+		data.URLs = urls[host]
+	}
+	renderIndex(w, &data)
+}
+
+// handleSearch prints the URLs of the entries matching the search form.
+func (h *cacheHandler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query, err := parseQuery(r.Form)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var data struct {
-		Hosts map[string]bool
-		URLs  []string
+	data := indexData{Search: true, Form: r.Form}
+	for entry, err := range h.Search(query) {
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		data.URLs = append(data.URLs, entry.URL())
 	}
+	renderIndex(w, &data)
+}
 
-	if len(host) == 0 {
-		data.Hosts = h.host
-	} else {
-		data.URLs = h.url[host]
+// parseQuery returns the query of the search form:
+//
+//	q        URL contains
+//	regexp   URL matches
+//	type     Content-Type contains
+//	server   Server contains
+//	status   response status code
+//	min-size minimum body size
+//	max-size maximum body size
+//	since    created at or after date or RFC 3339 time
+//	until    created before date or RFC 3339 time
+//	text     decoded textual body contains
+func parseQuery(form url.Values) (*cdc.Query, error) {
+	query := cdc.Query{
+		URL:    form.Get("q"),
+		Header: make(http.Header),
+		Text:   form.Get("text"),
 	}
 
-	w.Header().Set("Cache-Control", "no-cache, no-store")
-	err = t.Execute(w, data)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var err error
+	if expr := form.Get("regexp"); expr != "" {
+		query.URLRegexp, err = regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if value := form.Get("type"); value != "" {
+		query.Header.Set("Content-Type", value)
 	}
+	if value := form.Get("server"); value != "" {
+		query.Header.Set("Server", value)
+	}
+	if value := form.Get("status"); value != "" {
+		query.Status, err = strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if value := form.Get("min-size"); value != "" {
+		query.MinSize, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if value := form.Get("max-size"); value != "" {
+		query.MaxSize, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if query.Since, err = parseTime(form.Get("since")); err != nil {
+		return nil, err
+	}
+	if query.Until, err = parseTime(form.Get("until")); err != nil {
+		return nil, err
+	}
+	return &query, nil
+}
+
+// parseTime parses a date or a RFC 3339 time.
+// The zero time is returned for an empty string.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// renderIndex prints the index template with data.
+func renderIndex(w http.ResponseWriter, data *indexData) {
+	executeTemplate(w, "index.html", data)
 }
 
 // handleView prints the body of the view.
@@ -124,6 +278,10 @@ func (h *cacheHandler) handleView(w http.ResponseWriter, r *http.Request, view s
 		return
 	}
 
+	if len(r.FormValue("raw")) == 0 && h.handleRewrite(w, view, entry, header) {
+		return
+	}
+
 	body, err := entry.Body()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -131,18 +289,199 @@ func (h *cacheHandler) handleView(w http.ResponseWriter, r *http.Request, view s
 	}
 	defer body.Close()
 
-	lst := []string{"Content-Type", "Content-Length", "Content-Encoding"}
+	lst := []string{"Content-Type", "Content-Encoding", "Etag", "Last-Modified"}
 	for _, item := range lst {
 		value := header.Get(item)
 		if len(value) != 0 {
 			w.Header().Set(item, value)
 		}
 	}
+	w.Header().Set("Content-Length", strconv.Itoa(int(entry.DataSize[1])))
+
+	// revalidated with the stored validators
+	w.Header().Set("Cache-Control", "no-cache")
+	serveBody(w, r, header, body)
+}
+
+// serveBody serves the body with http.ServeContent, answering the range
+// and conditional requests with the Etag and Last-Modified of header.
+// The headers of the response must already be set.
+func serveBody(w http.ResponseWriter, r *http.Request, header http.Header, body io.ReadSeeker) {
+	modtime, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		modtime = time.Time{}
+	}
+	http.ServeContent(w, r, "", modtime, body)
+}
+
+// handleRewrite prints the HTML or CSS body of the view,
+// with its references rewritten to views.
+// It returns false if the body is not rewritten.
+func (h *cacheHandler) handleRewrite(w http.ResponseWriter, view string, entry *cdc.Entry, header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "text/css" {
+		return false
+	}
+	base, err := url.Parse(view)
+	if err != nil {
+		return false
+	}
+
+	body, err := entry.DecodedBody()
+	if errors.Is(err, cdc.ErrUnsupportedEncoding) {
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	defer body.Close()
+
+	b, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	rw := rewriter{
+		base: base,
+		exists: func(u string) bool {
+			_, err := h.GetAddr(u)
+			return err == nil
+		},
+	}
+	if mediaType == "text/html" {
+		b = rw.rewriteHTML(b)
+	} else {
+		b, _ = rw.rewriteCSS(b)
+	}
+
+	w.Header().Set("Content-Type", header.Get("Content-Type"))
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	_, _ = w.Write(b)
+	return true
+}
+
+// maxDump is the maximum size of the hex dump of a stream.
+const maxDump = 64 << 10
+
+// streamData is the data of a stream in the inspect template.
+type streamData struct {
+	Index     int
+	Size      int32
+	Addr      cdc.Addr
+	AddrInfo  cdc.AddrInfo
+	Dump      string
+	Truncated bool
+	Err       error
+}
+
+// handleInspect prints the details of the entry of url, or downloads
+// one of its streams with the "stream" form value, or its decoded body
+// with the "decoded" form value.
+func (h *cacheHandler) handleInspect(w http.ResponseWriter, r *http.Request, url string) {
+	entry, err := h.OpenURL(url)
+	if errors.Is(err, cdc.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if value := r.FormValue("stream"); value != "" {
+		index, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.handleDownload(w, entry, index)
+		return
+	}
+	if len(r.FormValue("decoded")) != 0 {
+		h.handleDownload(w, entry, -1)
+		return
+	}
+
+	data := struct {
+		*cdc.Entry
+		AddrInfo    cdc.AddrInfo
+		Rankings    *cdc.Rankings
+		RankingsErr error
+		Info        *cdc.ResponseInfo
+		InfoErr     error
+		Streams     []streamData
+	}{
+		Entry:    entry,
+		AddrInfo: entry.Addr().Info(),
+	}
+	data.Rankings, data.RankingsErr = entry.Rankings()
+	data.Info, data.InfoErr = entry.ResponseInfo()
+
+	for i := range entry.DataAddr {
+		stream := streamData{
+			Index:    i,
+			Size:     entry.DataSize[i],
+			Addr:     entry.DataAddr[i],
+			AddrInfo: entry.DataAddr[i].Info(),
+		}
+		stream.Dump, stream.Truncated, stream.Err = dumpStream(entry, i)
+		data.Streams = append(data.Streams, stream)
+	}
+	executeTemplate(w, "inspect.html", data)
+}
+
+// dumpStream returns the hex dump of the stream index, limited to maxDump bytes.
+func dumpStream(entry *cdc.Entry, index int) (dump string, truncated bool, err error) {
+	stream, err := entry.Stream(index)
+	if err != nil {
+		return "", false, err
+	}
+	defer stream.Close()
+
+	b, err := io.ReadAll(io.LimitReader(stream, maxDump+1))
+	if err != nil {
+		return "", false, err
+	}
+	if len(b) > maxDump {
+		b, truncated = b[:maxDump], true
+	}
+	return hex.Dump(b), truncated, nil
+}
+
+// handleDownload sends the stream index of the entry as an attachment,
+// or its decoded body if index is negative.
+func (h *cacheHandler) handleDownload(w http.ResponseWriter, entry *cdc.Entry, index int) {
+	var body io.ReadCloser
+	var err error
+	name := fmt.Sprintf("%d", entry.Addr())
+
+	if index < 0 {
+		body, err = entry.DecodedBody()
+		name += ".body"
+	} else {
+		body, err = entry.Stream(index)
+		name += fmt.Sprintf(".%d", index)
+	}
+	if errors.Is(err, cdc.ErrUnsupportedEncoding) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
 
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": name}))
 	w.Header().Set("Cache-Control", "no-cache, no-store")
 	_, err = io.Copy(w, body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("download %d: %v", entry.Addr(), err)
 	}
 }
 
@@ -202,30 +541,134 @@ const usage = `this is a webapp for reading Chromium disk cache v2.
 
 Usage:
 
-    go run main.go CACHEDIR
-
-CACHEDIR is the path to the chromium cache directory.
+    webapp [flag] CACHEDIR...
+
+The flags are:
+    -listen string      listen address (default ":8000")
+    -base string        base path of the webapp (default "/")
+    -tls-cert string    TLS certificate file, to serve HTTPS with -tls-key
+    -tls-key string     TLS private key file
+    -auth string        basic authentication credentials "user:password",
+                        or the CDC_AUTH environment variable
+    -watch duration     poll the caches for changes every duration, and push
+                        them to the live view of the home page (default 0, off)
+    -proxy              serve the cache as an HTTP forward proxy, with one CACHEDIR
+    -miss-status int    proxy status of the requests not in cache (default 504)
+    -ca-cert string     proxy CA certificate, created if missing (default "cdc-ca.pem")
+    -ca-key string      proxy CA private key, created if missing (default "cdc-ca-key.pem")
+
+CACHEDIR is the path to the chromium cache directory. With several
+directories, each cache is served under "/NAME/", where NAME is the
+base name of the directory, or given as NAME=CACHEDIR.
+
+The proxy only uses the -listen flag of the webapp.
 `
 
 func main() {
-	if len(os.Args) != 2 {
+	flags := flag.NewFlagSet("", flag.ExitOnError)
+	flags.Usage = func() {
 		log.SetFlags(0)
-		log.Fatal(usage)
+		log.Print(usage)
 	}
 
-	cache, err := cdc.OpenCache(os.Args[1])
-	if err != nil {
-		log.Fatal(err)
+	listen := flags.String("listen", ":8000", "")
+	base := flags.String("base", "/", "")
+	tlsCert := flags.String("tls-cert", "", "")
+	tlsKey := flags.String("tls-key", "", "")
+	auth := flags.String("auth", os.Getenv("CDC_AUTH"), "")
+	proxy := flags.Bool("proxy", false, "")
+	missStatus := flags.Int("miss-status", http.StatusGatewayTimeout, "")
+	caCert := flags.String("ca-cert", "cdc-ca.pem", "")
+	caKey := flags.String("ca-key", "cdc-ca-key.pem", "")
+	watch := flags.Duration("watch", 0, "")
+
+	_ = flags.Parse(os.Args[1:])
+	if flags.NArg() == 0 || (*proxy && flags.NArg() != 1) {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be set together")
+	}
+	if *watch < 0 {
+		log.Fatal("-watch must be positive")
+	}
+	if *auth != "" && !strings.Contains(*auth, ":") {
+		log.Fatal(`-auth must be "user:password"`)
 	}
 
-	handler := CacheHandler(cache)
-	http.Handle("/", handler)
+	caches := make(map[string]*cdc.Cache)
+	var first *cdc.Cache
+	// closeCaches closes the caches opened, before exiting
+	closeCaches := func() {
+		for _, cache := range caches {
+			_ = cache.Close()
+		}
+	}
+	for _, arg := range flags.Args() {
+		name, dir, err := parseCacheArg(arg)
+		if err != nil {
+			closeCaches()
+			log.Fatal(err)
+		}
+		if caches[name] != nil {
+			closeCaches()
+			log.Fatalf("duplicate cache name %q", name)
+		}
+
+		opts := cdc.Options{Logger: slog.Default()}
+		cache, err := cdc.OpenCacheWithOptions(dir, &opts)
+		if err != nil {
+			closeCaches()
+			log.Fatal(err)
+		}
+		caches[name] = cache
+		if first == nil {
+			first = cache
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := http.Server{
+		Addr: *listen,
+		// ends the long-lived requests, as the events, on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	if *proxy {
+		ca, err := loadCertAuthority(*caCert, *caKey)
+		if err != nil {
+			closeCaches()
+			log.Fatal(err)
+		}
+		log.Printf("proxy listening on %s, import %s in the browser to proxy HTTPS", *listen, *caCert)
+
+		server.Handler = ProxyHandler(first, *missStatus, ca)
+		err = serve(ctx, &server, "", "")
+		closeCaches()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	http.HandleFunc("/favicon.ico", http.NotFound)
-	http.HandleFunc("/favicon.png", http.NotFound)
-	http.HandleFunc("/opensearch.xml", http.NotFound)
+	handler := CacheHandler(first)
+	if len(caches) > 1 {
+		handler = CachesHandler(caches)
+	}
+	if *watch > 0 {
+		watchHandler(ctx, handler, *watch)
+	}
+	if *auth != "" {
+		handler = basicAuth(handler, *auth)
+	}
+	server.Handler = newServeMux(handler, *base)
 
-	err = http.ListenAndServe(":8000", nil)
+	log.Printf("listening on %s", *listen)
+	err := serve(ctx, &server, *tlsCert, *tlsKey)
+	closeCaches()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/schorlet/cdc"
@@ -26,6 +30,7 @@ func withContext(fn func(url string)) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer cache.Close()
 
 	handler := CacheHandler(cache)
 	server := httptest.NewServer(handler)
@@ -59,7 +64,7 @@ func TestView(t *testing.T) {
 				length:   "5186",
 				status:   http.StatusOK,
 			}, {
-				url:      makeURL(base, "https://golang.org/pkg/"),
+				url:      makeURL(base, "https://golang.org/pkg/") + "&raw=1",
 				mime:     "text/html; charset=utf-8",
 				encoding: "gzip",
 				length:   "8476",
@@ -119,3 +124,400 @@ func verify(t *testing.T, req request, res *http.Response) {
 		t.Fatalf("bad stream size: %d, want: %d", n, nlength)
 	}
 }
+
+func TestSearch(t *testing.T) {
+	withContext(func(base string) {
+		searches := []struct {
+			query string
+			body  string
+		}{
+			{"q=jquery.min", "https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js"},
+			{"q=jquery.min&type=javascript&text=jQuery+v1.8.2", "1 results"},
+			{"q=jquery.min&status=404", "0 results"},
+		}
+
+		for _, search := range searches {
+			res, err := http.Get(base + "/?" + search.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(res.Body)
+			_ = res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("search %s: bad statuscode: %d", search.query, res.StatusCode)
+			}
+			if !strings.Contains(string(b), search.body) {
+				t.Fatalf("search %s: %q not found", search.query, search.body)
+			}
+		}
+
+		res, err := http.Get(base + "/?q=&regexp=(")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("bad regexp: statuscode: %d, want: %d", res.StatusCode, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestInspect(t *testing.T) {
+	withContext(func(base string) {
+		jquery := url.QueryEscape("https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js")
+		inspects := []struct {
+			query  string
+			status int
+			body   string
+			length int
+		}{
+			{"inspect=" + jquery, http.StatusOK, "|............HTTP|", 0},
+			{"inspect=" + jquery + "&stream=1", http.StatusOK, "", 33397},
+			{"inspect=" + jquery + "&stream=2", http.StatusOK, "", 152728},
+			{"inspect=" + jquery + "&decoded=1", http.StatusOK, "/*! jQuery v1.8.2", 0},
+			{"inspect=" + jquery + "&stream=x", http.StatusBadRequest, "", 0},
+			{"inspect=https://golang.org/", http.StatusNotFound, "", 0},
+		}
+
+		for _, inspect := range inspects {
+			res, err := http.Get(base + "/?" + inspect.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(res.Body)
+			_ = res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != inspect.status {
+				t.Fatalf("inspect %s: bad statuscode: %d, want: %d",
+					inspect.query, res.StatusCode, inspect.status)
+			}
+			if !strings.Contains(string(b), inspect.body) {
+				t.Fatalf("inspect %s: %q not found", inspect.query, inspect.body)
+			}
+			if inspect.length != 0 && len(b) != inspect.length {
+				t.Fatalf("inspect %s: bad length: %d, want: %d",
+					inspect.query, len(b), inspect.length)
+			}
+		}
+	})
+}
+
+func TestAPI(t *testing.T) {
+	withContext(func(base string) {
+		jquery := "https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js"
+
+		get := func(path string, status int, v any) {
+			t.Helper()
+			res, err := http.Get(base + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != status {
+				t.Fatalf("%s: bad statuscode: %d, want: %d", path, res.StatusCode, status)
+			}
+			if mime := res.Header.Get("Content-Type"); mime != "application/json" {
+				t.Fatalf("%s: bad content-type: %q", path, mime)
+			}
+			err = json.NewDecoder(res.Body).Decode(v)
+			if err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+		}
+
+		var hosts []apiHost
+		get("/api/hosts", http.StatusOK, &hosts)
+		if len(hosts) != 3 || hosts[1] != (apiHost{Host: "golang.org", Entries: 17}) {
+			t.Fatalf("bad hosts: %v", hosts)
+		}
+
+		var page apiPage
+		get("/api/entries?offset=1&limit=1", http.StatusOK, &page)
+		if page.Total != 19 || len(page.Entries) != 1 || page.Entries[0].URL != jquery {
+			t.Fatalf("bad page: %+v", page)
+		}
+		get("/api/entries?host=ajax.googleapis.com&offset=1", http.StatusOK, &page)
+		if page.Total != 1 || len(page.Entries) != 0 {
+			t.Fatalf("bad page: %+v", page)
+		}
+
+		var entry apiEntryInfo
+		get("/api/lookup?url="+url.QueryEscape(jquery), http.StatusOK, &entry)
+		if entry.Status != 200 || entry.Header.Get("Content-Encoding") != "gzip" ||
+			len(entry.Streams) != 4 || entry.Streams[1].File.Name != "f_000001" {
+			t.Fatalf("bad entry: %+v", entry)
+		}
+		addr := strconv.Itoa(int(entry.Addr))
+		get("/api/entries/"+addr, http.StatusOK, &entry)
+		if entry.URL != jquery || entry.LastUsed.IsZero() {
+			t.Fatalf("bad entry: %+v", entry)
+		}
+
+		failures := []struct {
+			path   string
+			status int
+		}{
+			{"/api/lookup?url=https://golang.org/", http.StatusNotFound},
+			{"/api/entries/1", http.StatusNotFound},
+			{"/api/entries/x", http.StatusBadRequest},
+			{"/api/entries/" + addr + "/streams/4", http.StatusNotFound},
+			{"/api/entries?limit=x", http.StatusBadRequest},
+			{"/api/unknown", http.StatusNotFound},
+		}
+		for _, e := range failures {
+			var apiErr apiError
+			get(e.path, e.status, &apiErr)
+			if apiErr.Status != e.status || apiErr.Message == "" {
+				t.Fatalf("%s: bad error: %+v", e.path, apiErr)
+			}
+		}
+
+		res, err := http.Get(base + "/api/entries/" + addr + "/streams/2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) != 152728 {
+			t.Fatalf("bad stream size: %d, want: %d", len(b), 152728)
+		}
+	})
+}
+
+func TestAPIEntriesError(t *testing.T) {
+	dir := copyCache(t)
+	cache, err := cdc.OpenCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	jquery := "https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js"
+	entry, err := cache.OpenURL(jquery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(newCacheHandler(cache))
+	defer server.Close()
+
+	// the entry is past the end of its block file
+	info := entry.Addr().Info()
+	err = os.Truncate(filepath.Join(dir, info.FileName), info.Offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Get(server.URL + "/api/entries?host=ajax.googleapis.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var page apiPage
+	err = json.NewDecoder(res.Body).Decode(&page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || len(page.Entries) != 1 ||
+		page.Entries[0].URL != jquery || page.Entries[0].Error == "" {
+		t.Fatalf("bad page: %d %+v", res.StatusCode, page)
+	}
+}
+
+func TestRange(t *testing.T) {
+	withContext(func(base string) {
+		view := makeURL(base, "https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js")
+		modified := "Fri, 16 Oct 2015 18:27:31 GMT"
+
+		ranges := []struct {
+			header map[string]string
+			status int
+			length string
+		}{
+			{nil, http.StatusOK, "33397"},
+			{map[string]string{"Range": "bytes=10-19"}, http.StatusPartialContent, "10"},
+			{map[string]string{"Range": "bytes=-100"}, http.StatusPartialContent, "100"},
+			{map[string]string{"Range": "bytes=40000-"}, http.StatusRequestedRangeNotSatisfiable, "33"},
+			{map[string]string{"Range": "bytes=0-9", "If-Range": modified}, http.StatusPartialContent, "10"},
+			{map[string]string{"Range": "bytes=0-9", "If-Range": "Sat, 17 Oct 2015 18:27:31 GMT"}, http.StatusOK, "33397"},
+			{map[string]string{"If-Modified-Since": modified}, http.StatusNotModified, ""},
+			{map[string]string{"If-Modified-Since": "Thu, 15 Oct 2015 18:27:31 GMT"}, http.StatusOK, "33397"},
+			{map[string]string{"If-None-Match": "*"}, http.StatusNotModified, ""},
+		}
+
+		client := http.Client{
+			Transport: &http.Transport{
+				DisableCompression: true,
+			},
+		}
+		defer client.CloseIdleConnections()
+
+		for _, rg := range ranges {
+			req, err := http.NewRequest(http.MethodGet, view, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range rg.header {
+				req.Header.Set(key, value)
+			}
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+
+			if res.StatusCode != rg.status {
+				t.Fatalf("%v: bad statuscode: %d, want: %d", rg.header, res.StatusCode, rg.status)
+			}
+			if length := res.Header.Get("Content-Length"); length != rg.length {
+				t.Fatalf("%v: bad content-length: %q, want: %q", rg.header, length, rg.length)
+			}
+		}
+	})
+}
+
+func TestCaches(t *testing.T) {
+	caches := make(map[string]*cdc.Cache)
+	for _, name := range []string{"chrome", "brave"} {
+		cache, err := cdc.OpenCache("../../testdata")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cache.Close()
+		caches[name] = cache
+	}
+
+	server := httptest.NewServer(CachesHandler(caches))
+	defer server.Close()
+
+	jquery := url.QueryEscape("https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js")
+	requests := []struct {
+		path    string
+		referer string
+		status  int
+		body    string
+	}{
+		{"/", "", http.StatusOK, `<a href="brave/">brave</a>`},
+		{"/?q=jquery.min", "", http.StatusOK, "2 results"},
+		{"/?q=jquery.min", "", http.StatusOK, `[chrome]</small> <a href="chrome/?view=`},
+		{"/chrome", "", http.StatusOK, "golang.org"},
+		{"/brave/?host=golang.org", "", http.StatusOK, "https://golang.org/pkg/"},
+		{"/brave/?q=jquery.min", "", http.StatusOK, "1 results"},
+		{"/brave/?view=" + jquery, "", http.StatusOK, ""},
+		{"/brave/api/hosts", "", http.StatusOK, `"host":"golang.org"`},
+		{"/ajax/libs/jquery/1.8.2/jquery.min.js", server.URL + "/brave/?view=" + jquery, http.StatusOK, ""},
+		{"/ajax/libs/jquery/1.8.2/jquery.min.js", "", http.StatusBadRequest, ""},
+		{"/firefox/", "", http.StatusBadRequest, ""},
+	}
+
+	for _, req := range requests {
+		r, err := http.NewRequest(http.MethodGet, server.URL+req.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.referer != "" {
+			r.Header.Set("Referer", req.referer)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != req.status {
+			t.Fatalf("%s: bad statuscode: %d, want: %d", req.path, res.StatusCode, req.status)
+		}
+		if !strings.Contains(string(b), req.body) {
+			t.Fatalf("%s: %q not found", req.path, req.body)
+		}
+	}
+}
+
+func TestParseCacheArg(t *testing.T) {
+	args := []struct {
+		arg, name, dir string
+	}{
+		{"../../testdata", "testdata", "../../testdata"},
+		{"/home/u/.cache/chromium/Default/Cache/", "Cache", "/home/u/.cache/chromium/Default/Cache/"},
+		{"brave=/tmp/Cache", "brave", "/tmp/Cache"},
+		{"a/b=/tmp/Cache", "", ""},
+		{"=/tmp/Cache", "", ""},
+	}
+	for _, a := range args {
+		name, dir, err := parseCacheArg(a.arg)
+		if a.name == "" {
+			if err == nil {
+				t.Fatalf("%s: no error", a.arg)
+			}
+			continue
+		}
+		if err != nil || name != a.name || dir != a.dir {
+			t.Fatalf("%s: %q, %q, %v, want: %q, %q", a.arg, name, dir, err, a.name, a.dir)
+		}
+	}
+}
+
+func TestServeMux(t *testing.T) {
+	cache, err := cdc.OpenCache("../../testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	handler := basicAuth(CacheHandler(cache), "user:secret")
+	server := httptest.NewServer(newServeMux(handler, "/cdc"))
+	defer server.Close()
+
+	requests := []struct {
+		path     string
+		user     string
+		password string
+		status   int
+		body     string
+	}{
+		{"/cdc/", "", "", http.StatusUnauthorized, ""},
+		{"/cdc/", "user", "wrong", http.StatusUnauthorized, ""},
+		{"/cdc/", "user", "secret", http.StatusOK, `href="?host=golang.org"`},
+		{"/cdc/_cdc/style.css", "user", "secret", http.StatusOK, "body {"},
+		{"/cdc/_cdc/favicon.svg", "user", "secret", http.StatusOK, "<svg"},
+		{"/cdc/api/hosts", "user", "secret", http.StatusOK, `"host":"golang.org"`},
+		{"/", "user", "secret", http.StatusNotFound, ""},
+		{"/favicon.ico", "", "", http.StatusNotFound, ""},
+	}
+
+	for _, req := range requests {
+		r, err := http.NewRequest(http.MethodGet, server.URL+req.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.user != "" {
+			r.SetBasicAuth(req.user, req.password)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != req.status {
+			t.Fatalf("%s: bad statuscode: %d, want: %d", req.path, res.StatusCode, req.status)
+		}
+		if !strings.Contains(string(b), req.body) {
+			t.Fatalf("%s: %q not found", req.path, req.body)
+		}
+	}
+}
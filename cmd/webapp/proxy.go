@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/schorlet/cdc"
+)
+
+// hopHeaders are the hop-by-hop headers, not forwarded by proxies.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type proxyHandler struct {
+	*cdc.Cache
+	missStatus int            // status of the responses not in cache
+	ca         *certAuthority // signs the certificates of CONNECT hosts
+}
+
+// ProxyHandler returns a handler that acts as an HTTP forward proxy,
+// answering the requests with the responses stored in the specified cache.
+// The requests not in cache are answered with missStatus.
+// HTTPS requests are intercepted with CONNECT, the certificates of the
+// hosts are signed by ca. If ca is nil, CONNECT is not supported.
+func ProxyHandler(cache *cdc.Cache, missStatus int, ca *certAuthority) http.Handler {
+	return &proxyHandler{
+		Cache:      cache,
+		missStatus: missStatus,
+		ca:         ca,
+	}
+}
+
+// ServeHTTP responds to an HTTP proxy request.
+func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.RequestURI)
+
+	if r.Method == http.MethodConnect {
+		h.handleConnect(w, r)
+
+	} else if r.URL.IsAbs() {
+		h.handleEntry(w, r, r.URL.String())
+
+	} else {
+		http.Error(w, "cdc: not a proxy request", http.StatusBadRequest)
+	}
+}
+
+// handleEntry responds with the status, header and body stored for url.
+func (h *proxyHandler) handleEntry(w http.ResponseWriter, r *http.Request, url string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "cdc: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entry, err := h.OpenURL(url)
+	if err == cdc.ErrNotFound {
+		http.Error(w, "cdc: not in cache: "+url, h.missStatus)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	info, err := entry.ResponseInfo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := entry.Body()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	header := w.Header()
+	for key, values := range info.Header {
+		header[key] = values
+	}
+	for _, key := range hopHeaders {
+		header.Del(key)
+	}
+	header.Del("Status")
+	header.Set("Content-Length", strconv.Itoa(int(entry.DataSize[1])))
+
+	status := info.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status == http.StatusOK {
+		serveBody(w, r, info.Header, body)
+		return
+	}
+	w.WriteHeader(status)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, err = io.Copy(w, body)
+	if err != nil {
+		log.Printf("proxy %s: %v", url, err)
+	}
+}
+
+// handleConnect intercepts the TLS connection to the requested host
+// and serves the HTTPS requests from the cache.
+func (h *proxyHandler) handleConnect(w http.ResponseWriter, r *http.Request) {
+	if h.ca == nil {
+		http.Error(w, "cdc: CONNECT not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cdc: hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = rw.WriteString("HTTP/1.1 200 Connection Established\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		log.Printf("connect %s: %v", r.Host, err)
+		_ = conn.Close()
+		return
+	}
+
+	config := tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = host
+			}
+			return h.ca.certificate(name)
+		},
+	}
+	tlsConn := tls.Server(&bufferedConn{Conn: conn, r: rw.Reader}, &config)
+
+	server := http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log.Println(r.Method, "https://"+r.Host+r.RequestURI)
+			r.URL.Scheme = "https"
+			r.URL.Host = r.Host
+			h.handleEntry(w, r, r.URL.String())
+		}),
+	}
+	_ = server.Serve(newConnListener(tlsConn))
+}
+
+// bufferedConn is a net.Conn reading first from the buffer of the hijacked connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// connListener is a net.Listener accepting one connection.
+// Accept blocks once the connection is accepted, until it is closed.
+type connListener struct {
+	conn   net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	l := connListener{closed: make(chan struct{})}
+	l.conn = &closeNotifyConn{Conn: conn, closed: l.close}
+	return &l
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	if conn := l.conn; conn != nil {
+		l.conn = nil
+		return conn, nil
+	}
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *connListener) Close() error {
+	l.close()
+	return nil
+}
+
+func (l *connListener) close() {
+	l.once.Do(func() { close(l.closed) })
+}
+
+func (l *connListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// closeNotifyConn is a net.Conn calling closed when it is closed.
+type closeNotifyConn struct {
+	net.Conn
+	closed func()
+}
+
+func (c *closeNotifyConn) Close() error {
+	c.closed()
+	return c.Conn.Close()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/schorlet/cdc"
+)
+
+func withProxy(fn func(client *http.Client)) {
+	cache, err := cdc.OpenCache("../../testdata")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cache.Close()
+
+	ca, err := newCertAuthority()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handler := ProxyHandler(cache, http.StatusGatewayTimeout, ca)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	proxyURL, _ := url.Parse(server.URL)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	client := http.Client{
+		Transport: &http.Transport{
+			Proxy:              http.ProxyURL(proxyURL),
+			TLSClientConfig:    &tls.Config{RootCAs: roots},
+			DisableCompression: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	fn(&client)
+}
+
+func TestProxy(t *testing.T) {
+	withProxy(func(client *http.Client) {
+		requests := []request{
+			{
+				url:      "https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js",
+				mime:     "text/javascript; charset=UTF-8",
+				encoding: "gzip",
+				length:   "33397",
+				status:   http.StatusOK,
+			}, {
+				url:    "https://golang.org/doc/gopher/pkg.png",
+				mime:   "image/png",
+				length: "5409",
+				status: http.StatusOK,
+			}, {
+				url:    "https://golang.org/",
+				mime:   "text/plain; charset=utf-8",
+				length: "39",
+				status: http.StatusGatewayTimeout,
+			}, {
+				url:    "http://golang.org/pkg/",
+				mime:   "text/plain; charset=utf-8",
+				length: "42",
+				status: http.StatusGatewayTimeout,
+			},
+		}
+
+		for _, req := range requests {
+			res, err := client.Get(req.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verify(t, req, res)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, requests[0].url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Range", "bytes=0-9")
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != http.StatusPartialContent || res.ContentLength != 10 {
+			t.Fatalf("bad range response: %d, length: %d", res.StatusCode, res.ContentLength)
+		}
+	})
+}
+
+func TestLoadCertAuthority(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "ca.pem")
+	keyFile := filepath.Join(dir, "ca-key.pem")
+
+	ca, err := loadCertAuthority(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadCertAuthority(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.cert.Equal(ca.cert) || !loaded.key.Equal(ca.key) {
+		t.Fatal("loaded CA differs from the created one")
+	}
+
+	// the key is kept if the certificate is missing
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadCertAuthority(certFile, keyFile)
+	if err == nil {
+		t.Fatal("want an error without the certificate")
+	}
+	b, err := os.ReadFile(keyFile)
+	if err != nil || !bytes.Equal(b, key) {
+		t.Fatalf("key overwritten: %v", err)
+	}
+	_, err = loadCertAuthority(keyFile, certFile)
+	if err == nil {
+		t.Fatal("want an error without the key")
+	}
+}
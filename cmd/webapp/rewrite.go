@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// missingStyle outlines the elements referencing resources not in cache.
+const missingStyle = `<style>[data-cdc-missing]{outline:1px dashed red}</style>`
+
+var (
+	// tagPattern matches comments and tags with their attributes.
+	tagPattern = regexp.MustCompile(
+		`(?s)<!--.*?-->|<(/?)([a-zA-Z][a-zA-Z0-9-]*)((?:[^>"']|"[^"]*"|'[^']*')*?)(/?)>`)
+
+	// attrPattern matches the attributes of a tag.
+	attrPattern = regexp.MustCompile(
+		`([^\s"'>/=]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+
+	// cssPattern matches the url() and @import references of a stylesheet.
+	cssPattern = regexp.MustCompile(
+		`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)|@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+// urlAttrs are the attributes holding one URL.
+var urlAttrs = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"poster":     true,
+	"data":       true,
+	"background": true,
+}
+
+// rewriter rewrites the references of HTML and CSS documents
+// to the views of the cache.
+type rewriter struct {
+	base   *url.URL          // URL of the document
+	exists func(string) bool // reports whether a URL is in cache
+}
+
+// view returns the view of the reference ref, and whether it is in cache.
+// ok is false if ref can not be rewritten.
+func (rw *rewriter) view(ref string) (view string, found, ok bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return "", false, false
+	}
+
+	u, err := rw.base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false, false
+	}
+
+	fragment := u.EscapedFragment()
+	u.Fragment, u.RawFragment = "", ""
+	abs := u.String()
+
+	view = "?view=" + url.QueryEscape(abs)
+	if fragment != "" {
+		view += "#" + fragment
+	}
+	return view, rw.exists(abs), true
+}
+
+// rewriteHTML rewrites the references of the tags, the inline styles
+// and the style elements of an HTML document.
+func (rw *rewriter) rewriteHTML(doc []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(doc) + len(doc)/8)
+
+	pos := 0
+	for pos < len(doc) {
+		loc := tagPattern.FindSubmatchIndex(doc[pos:])
+		if loc == nil {
+			break
+		}
+		for i := range loc {
+			if loc[i] >= 0 {
+				loc[i] += pos
+			}
+		}
+		out.Write(doc[pos:loc[0]])
+		pos = loc[1]
+
+		if loc[2] < 0 { // comment
+			out.Write(doc[loc[0]:loc[1]])
+			continue
+		}
+
+		closing := loc[3] > loc[2]
+		name := strings.ToLower(string(doc[loc[4]:loc[5]]))
+		attrs := doc[loc[6]:loc[7]]
+		selfClosing := loc[9] > loc[8]
+
+		if closing {
+			out.Write(doc[loc[0]:loc[1]])
+			continue
+		}
+
+		rewritten, missing := rw.rewriteAttrs(name, attrs)
+		out.WriteByte('<')
+		out.Write(doc[loc[4]:loc[5]])
+		out.Write(rewritten)
+		if missing {
+			out.WriteString(" data-cdc-missing")
+		}
+		if selfClosing {
+			out.WriteByte('/')
+		}
+		out.WriteByte('>')
+
+		switch name {
+		case "head":
+			out.WriteString(missingStyle)
+
+		case "script", "style", "textarea", "title":
+			// raw text up to the closing tag
+			end := indexClosingTag(doc[pos:], name)
+			text := doc[pos : pos+end]
+			if name == "style" {
+				text, _ = rw.rewriteCSS(text)
+			}
+			out.Write(text)
+			pos += end
+		}
+	}
+
+	out.Write(doc[pos:])
+	return out.Bytes()
+}
+
+// indexClosingTag returns the index of the closing tag of name in text,
+// or the length of text if not found.
+func indexClosingTag(text []byte, name string) int {
+	lower := bytes.ToLower(text)
+	i := bytes.Index(lower, []byte("</"+name))
+	if i < 0 {
+		return len(text)
+	}
+	return i
+}
+
+// rewriteAttrs rewrites the references of the attributes of a tag.
+// missing is true if a rewritten reference is not in cache.
+func (rw *rewriter) rewriteAttrs(tag string, attrs []byte) (out []byte, missing bool) {
+	var buf bytes.Buffer
+	pos := 0
+
+	for _, loc := range attrPattern.FindAllSubmatchIndex(attrs, -1) {
+		name := strings.ToLower(string(attrs[loc[2]:loc[3]]))
+
+		// value index in the submatches: double, single or unquoted
+		v := -1
+		for _, i := range []int{4, 6, 8} {
+			if loc[i] >= 0 {
+				v = i
+				break
+			}
+		}
+		if v < 0 {
+			continue
+		}
+		value := html.UnescapeString(string(attrs[loc[v]:loc[v+1]]))
+
+		var replaced string
+		var found, ok bool
+
+		switch {
+		case urlAttrs[name]:
+			replaced, found, ok = rw.view(value)
+			if ok && tag == "base" && name == "href" {
+				// the following references are relative to the base
+				if u, err := rw.base.Parse(value); err == nil {
+					rw.base = u
+				}
+			}
+
+		case name == "srcset":
+			replaced, found, ok = rw.rewriteSrcset(value)
+
+		case name == "style":
+			css, exists := rw.rewriteCSS([]byte(value))
+			replaced = string(css)
+			found, ok = exists, replaced != value
+		}
+
+		if !ok {
+			continue
+		}
+		missing = missing || !found
+
+		buf.Write(attrs[pos:loc[v]])
+		if v == 8 {
+			// unquoted values may need quotes once rewritten
+			buf.WriteString(`"` + html.EscapeString(replaced) + `"`)
+		} else {
+			buf.WriteString(html.EscapeString(replaced))
+		}
+		pos = loc[v+1]
+	}
+
+	if pos == 0 {
+		return attrs, missing
+	}
+	buf.Write(attrs[pos:])
+	return buf.Bytes(), missing
+}
+
+// rewriteSrcset rewrites the URLs of a srcset attribute, a list of
+// "URL [descriptors]" separated by commas. As parsed by browsers, a URL
+// ends at a whitespace, or at the commas ending it: the other commas,
+// like those of a data: URL, are part of the URL.
+func (rw *rewriter) rewriteSrcset(srcset string) (string, bool, bool) {
+	const space = " \t\n\f\r"
+	var candidates []string
+	found, ok := true, false
+
+	for s := srcset; ; {
+		s = strings.TrimLeft(s, space+",")
+		if s == "" {
+			break
+		}
+		end := strings.IndexAny(s, space)
+		if end < 0 {
+			end = len(s)
+		}
+		url, descriptors := s[:end], ""
+		s = s[end:]
+
+		if trimmed := strings.TrimRight(url, ","); trimmed != url {
+			url = trimmed
+		} else {
+			// up to the next comma, outside of parentheses
+			depth, i := 0, 0
+		descriptor:
+			for ; i < len(s); i++ {
+				switch s[i] {
+				case '(':
+					depth++
+				case ')':
+					depth = max(depth-1, 0)
+				case ',':
+					if depth == 0 {
+						break descriptor
+					}
+				}
+			}
+			descriptors = strings.Join(strings.Fields(s[:i]), " ")
+			s = s[i:]
+		}
+
+		candidate := url
+		if view, exists, rewritten := rw.view(url); rewritten {
+			candidate = view
+			found = found && exists
+			ok = true
+		}
+		if descriptors != "" {
+			candidate += " " + descriptors
+		}
+		candidates = append(candidates, candidate)
+	}
+	return strings.Join(candidates, ", "), found, ok
+}
+
+// rewriteCSS rewrites the url() and @import references of a stylesheet.
+// It reports whether all the references rewritten are in the cache.
+func (rw *rewriter) rewriteCSS(css []byte) ([]byte, bool) {
+	found := true
+	css = cssPattern.ReplaceAllFunc(css, func(match []byte) []byte {
+		sub := cssPattern.FindSubmatch(match)
+
+		var ref string
+		for _, s := range sub[1:] {
+			if len(s) != 0 {
+				ref = string(s)
+				break
+			}
+		}
+		view, exists, ok := rw.view(ref)
+		if !ok {
+			return match
+		}
+		found = found && exists
+
+		if bytes.HasPrefix(bytes.ToLower(match), []byte("@import")) {
+			return []byte(`@import "` + view + `"`)
+		}
+		return []byte(`url("` + view + `")`)
+	})
+	return css, found
+}
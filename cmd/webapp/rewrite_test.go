@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func newRewriter(base string) *rewriter {
+	u, _ := url.Parse(base)
+	return &rewriter{
+		base: u,
+		exists: func(u string) bool {
+			return u != "https://golang.org/missing.png"
+		},
+	}
+}
+
+func TestRewriteHTML(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{
+			`<a href="/pkg/io/#Reader">io</a>`,
+			`<a href="?view=https%3A%2F%2Fgolang.org%2Fpkg%2Fio%2F#Reader">io</a>`,
+		}, {
+			`<img src=../doc/gopher/pkg.png alt=gopher>`,
+			`<img src="?view=https%3A%2F%2Fgolang.org%2Fdoc%2Fgopher%2Fpkg.png" alt=gopher>`,
+		}, {
+			`<img src='/missing.png'/>`,
+			`<img src='?view=https%3A%2F%2Fgolang.org%2Fmissing.png' data-cdc-missing/>`,
+		}, {
+			`<img srcset="a.png 1x, //cdn.org/b.png 2x">`,
+			`<img srcset="?view=https%3A%2F%2Fgolang.org%2Fpkg%2Fa.png 1x, ?view=https%3A%2F%2Fcdn.org%2Fb.png 2x">`,
+		}, {
+			`<div style="background: url('bg.png')">`,
+			`<div style="background: url(&#34;?view=https%3A%2F%2Fgolang.org%2Fpkg%2Fbg.png&#34;)">`,
+		}, {
+			`<div style="background: url(/missing.png)">`,
+			`<div style="background: url(&#34;?view=https%3A%2F%2Fgolang.org%2Fmissing.png&#34;)" data-cdc-missing>`,
+		}, {
+			`<img srcset="data:image/png;base64,AAAA 1x, /a,b.png 2x,/c.png 3x">`,
+			`<img srcset="data:image/png;base64,AAAA 1x, ?view=https%3A%2F%2Fgolang.org%2Fa%2Cb.png 2x, ?view=https%3A%2F%2Fgolang.org%2Fc.png 3x">`,
+		}, {
+			`<img srcset="a.png,, /missing.png 2x">`,
+			`<img srcset="?view=https%3A%2F%2Fgolang.org%2Fpkg%2Fa.png, ?view=https%3A%2F%2Fgolang.org%2Fmissing.png 2x" data-cdc-missing>`,
+		}, {
+			`<a href="#top">top</a><a href="mailto:a@b.c">mail</a>`,
+			`<a href="#top">top</a><a href="mailto:a@b.c">mail</a>`,
+		}, {
+			`<script src="/lib/godoc/godocs.js"></script><script>var a = "<a href='/x'>";</script>`,
+			`<script src="?view=https%3A%2F%2Fgolang.org%2Flib%2Fgodoc%2Fgodocs.js"></script><script>var a = "<a href='/x'>";</script>`,
+		}, {
+			`<!-- <a href="/x"> --><style>@import "style.css";</style>`,
+			`<!-- <a href="/x"> --><style>@import "?view=https%3A%2F%2Fgolang.org%2Fpkg%2Fstyle.css";</style>`,
+		}, {
+			`<base href="https://example.org/docs/"><a href="a.html?x=1&amp;y=2">`,
+			`<base href="?view=https%3A%2F%2Fexample.org%2Fdocs%2F"><a href="?view=https%3A%2F%2Fexample.org%2Fdocs%2Fa.html%3Fx%3D1%26y%3D2">`,
+		}, {
+			`<head><title>a <b></title>`,
+			`<head>` + missingStyle + `<title>a <b></title>`,
+		},
+	}
+
+	for _, tt := range tests {
+		out := newRewriter("https://golang.org/pkg/").rewriteHTML([]byte(tt.in))
+		if string(out) != tt.out {
+			t.Errorf("rewrite: %s\n got: %s\nwant: %s", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestRewriteCSS(t *testing.T) {
+	in := `body { background: url(img/bg.png) } @import 'print.css'; .a { background: url("data:image/png;base64,AAAA") }`
+	want := `body { background: url("?view=https%3A%2F%2Fgolang.org%2Flib%2Fimg%2Fbg.png") } @import "?view=https%3A%2F%2Fgolang.org%2Flib%2Fprint.css"; .a { background: url("data:image/png;base64,AAAA") }`
+
+	out, found := newRewriter("https://golang.org/lib/style.css").rewriteCSS([]byte(in))
+	if string(out) != want || !found {
+		t.Errorf("rewrite:\n got: %s, %t\nwant: %s, true", out, found, want)
+	}
+
+	_, found = newRewriter("https://golang.org/lib/style.css").rewriteCSS([]byte(`a { background: url(/missing.png) }`))
+	if found {
+		t.Error("rewrite missing: found")
+	}
+}
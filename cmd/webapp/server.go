@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"errors"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// staticPath is the path of the static assets, relative to the pages.
+const staticPath = "/_cdc/"
+
+var (
+	//go:embed index.html inspect.html
+	templateFS embed.FS
+
+	//go:embed static
+	staticFS embed.FS
+
+	// templates are the templates of the pages, parsed once.
+	templates = template.Must(template.ParseFS(templateFS, "*.html"))
+
+	// staticHandler serves the static assets under staticPath.
+	staticHandler = http.StripPrefix(strings.TrimSuffix(staticPath, "/"),
+		http.FileServerFS(must(fs.Sub(staticFS, "static"))))
+)
+
+func must(fsys fs.FS, err error) fs.FS {
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
+
+// executeTemplate prints the template name with data.
+func executeTemplate(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	err := templates.ExecuteTemplate(w, name, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// newServeMux returns the handler serving handler under the base path.
+func newServeMux(handler http.Handler, base string) http.Handler {
+	base = "/" + strings.Trim(base, "/") + "/"
+	if base == "//" {
+		base = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(base, http.StripPrefix(strings.TrimSuffix(base, "/"), handler))
+	mux.HandleFunc("/favicon.ico", http.NotFound)
+	mux.HandleFunc("/opensearch.xml", http.NotFound)
+	return mux
+}
+
+// basicAuth returns a handler requiring the credentials "user:password"
+// with the HTTP basic authentication.
+func basicAuth(handler http.Handler, credentials string) http.Handler {
+	want := sha256.Sum256([]byte(credentials))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		got := sha256.Sum256([]byte(user + ":" + password))
+		if !ok || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="cdc", charset="UTF-8"`)
+			http.Error(w, "cdc: unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// serve serves HTTP, or HTTPS if certFile and keyFile are set,
+// until ctx is done and the server is gracefully shut down.
+func serve(ctx context.Context, server *http.Server, certFile, keyFile string) error {
+	errc := make(chan error, 1)
+	go func() {
+		if certFile != "" || keyFile != "" {
+			errc <- server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			errc <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Print("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	if err2 := <-errc; !errors.Is(err2, http.ErrServerClosed) && err == nil {
+		err = err2
+	}
+	return err
+}
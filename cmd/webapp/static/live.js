@@ -0,0 +1,29 @@
+// live lists the entries as they are cached, from the events of the cache.
+(function () {
+    var live = document.getElementById("live");
+    if (!live || !window.EventSource) {
+        return;
+    }
+
+    var source = new EventSource("_cdc/events");
+    ["added", "updated", "removed"].forEach(function (type) {
+        source.addEventListener(type, function (e) {
+            var event = JSON.parse(e.data);
+            var div = document.createElement("div");
+
+            var label = document.createElement("small");
+            label.textContent = "[" + event.type + "] ";
+            div.appendChild(label);
+
+            if (event.type === "removed") {
+                div.appendChild(document.createTextNode(event.url));
+            } else {
+                var a = document.createElement("a");
+                a.href = "?view=" + encodeURIComponent(event.url);
+                a.textContent = event.url;
+                div.appendChild(a);
+            }
+            live.insertBefore(div, live.firstChild);
+        });
+    });
+})();
@@ -0,0 +1,189 @@
+package cdc
+
+import (
+	"crypto/sha256"
+	"io"
+	"slices"
+)
+
+// Difference is an entry added, removed or updated between two caches.
+type Difference struct {
+	Type EventType
+	URL  string
+	Old  Addr // Address in the old cache, zero if added.
+	New  Addr // Address in the new cache, zero if removed.
+
+	// The fields which differ if updated, among "created",
+	// "size", "header" and "body", in this order.
+	Fields []string
+
+	// Err is the error reading the entries, their differences are
+	// then unknown. The entries which could not be opened have no
+	// address.
+	Err error
+}
+
+// Diff compares the entries of the old and new caches by URL, and returns
+// their differences sorted by URL. An entry is updated if its creation time,
+// the size of its streams, its response info or the hash of its body differ.
+// The entries which could not be read are returned with their error.
+func Diff(oldCache, newCache *Cache) []Difference {
+	return DiffQuery(oldCache, newCache, nil)
+}
+
+// DiffQuery is like Diff, comparing only the entries matching query,
+// in the new cache if present. A nil query matches all the entries.
+func DiffQuery(oldCache, newCache *Cache, query *Query) []Difference {
+	oldURLs, newURLs := oldCache.URLs(), newCache.URLs()
+	slices.Sort(oldURLs)
+	slices.Sort(newURLs)
+	oldURLs, newURLs = slices.Compact(oldURLs), slices.Compact(newURLs)
+
+	var diffs []Difference
+	i, j := 0, 0
+	for i < len(oldURLs) || j < len(newURLs) {
+		var diff *Difference
+		switch {
+		case j == len(newURLs) || (i < len(oldURLs) && oldURLs[i] < newURLs[j]):
+			diff = diffOne(oldCache, oldURLs[i], EntryRemoved, query)
+			i++
+
+		case i == len(oldURLs) || newURLs[j] < oldURLs[i]:
+			diff = diffOne(newCache, newURLs[j], EntryAdded, query)
+			j++
+
+		default:
+			diff = diffEntry(oldCache, newCache, oldURLs[i], query)
+			i++
+			j++
+		}
+		if diff != nil {
+			diffs = append(diffs, *diff)
+		}
+	}
+	return diffs
+}
+
+// diffOne returns the entry of url in cache as removed or added,
+// or nil if it does not match query.
+func diffOne(cache *Cache, url string, typ EventType, query *Query) *Difference {
+	diff := Difference{Type: typ, URL: url}
+	var addr Addr
+	var err error
+	if query == nil {
+		addr, err = cache.GetAddr(url)
+	} else {
+		var entry *Entry
+		entry, err = cache.OpenURL(url)
+		if err == nil {
+			addr = entry.addr
+			var ok bool
+			if ok, err = query.Match(entry); err == nil && !ok {
+				return nil
+			}
+		}
+	}
+	diff.Err = err
+
+	if typ == EntryRemoved {
+		diff.Old = addr
+	} else {
+		diff.New = addr
+	}
+	return &diff
+}
+
+// diffEntry compares the entries of url in the old and new caches,
+// and returns nil if they do not differ or the new entry does not
+// match query.
+func diffEntry(oldCache, newCache *Cache, url string, query *Query) *Difference {
+	diff := Difference{Type: EntryUpdated, URL: url}
+	newEntry, err := newCache.OpenURL(url)
+	if err != nil {
+		diff.Err = err
+		return &diff
+	}
+	diff.New = newEntry.addr
+	if ok, err := matchEntry(query, newEntry); err != nil {
+		diff.Err = err
+		return &diff
+	} else if !ok {
+		return nil
+	}
+
+	oldEntry, err := oldCache.OpenURL(url)
+	if err != nil {
+		diff.Err = err
+		return &diff
+	}
+	diff.Old = oldEntry.addr
+
+	diff.Fields, diff.Err = diffFields(oldEntry, newEntry)
+	if diff.Err == nil && len(diff.Fields) == 0 {
+		return nil
+	}
+	return &diff
+}
+
+// diffFields returns the fields of the entries which differ.
+func diffFields(oldEntry, newEntry *Entry) ([]string, error) {
+	var fields []string
+	if oldEntry.CreationTime != newEntry.CreationTime {
+		fields = append(fields, "created")
+	}
+	if oldEntry.DataSize != newEntry.DataSize {
+		fields = append(fields, "size")
+	}
+
+	oldInfo, err := oldEntry.ResponseInfo()
+	if err != nil {
+		return nil, err
+	}
+	newInfo, err := newEntry.ResponseInfo()
+	if err != nil {
+		return nil, err
+	}
+	if oldInfo.StatusLine != newInfo.StatusLine ||
+		!slices.Equal(oldInfo.HeaderFields, newInfo.HeaderFields) {
+		fields = append(fields, "header")
+	}
+
+	oldSum, err := oldEntry.bodySum()
+	if err != nil {
+		return nil, err
+	}
+	newSum, err := newEntry.bodySum()
+	if err != nil {
+		return nil, err
+	}
+	if oldSum != newSum {
+		fields = append(fields, "body")
+	}
+	return fields, nil
+}
+
+// matchEntry reports whether the entry matches query, if not nil.
+func matchEntry(query *Query, entry *Entry) (bool, error) {
+	if query == nil {
+		return true, nil
+	}
+	return query.Match(entry)
+}
+
+// bodySum returns the SHA-256 hash of the body, as stored.
+func (e *Entry) bodySum() ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	body, err := e.Stream(1)
+	if err != nil {
+		return sum, err
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, body)
+	if err != nil {
+		return sum, &EntryError{Op: "body", Addr: e.addr, Err: err}
+	}
+	hash.Sum(sum[:0])
+	return sum, nil
+}
@@ -0,0 +1,92 @@
+package cdc
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	oldDir, newDir := copyCache(t), copyCache(t)
+
+	oldCache, err := OpenCache(oldDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oldCache.Close()
+
+	jquery := "https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js"
+	entry, err := oldCache.OpenURL(jquery)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CreationTime of the entry
+	created := binary.LittleEndian.AppendUint64(nil, entry.CreationTime+1)
+	writeAt(t, newDir, entry.addr.fileName(), entry.addr.blockOffset()+24, created)
+
+	// DataSize of the response info of an entry, out of range
+	css := "https://golang.org/lib/godoc/style.css"
+	cssEntry, err := oldCache.OpenURL(css)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAt(t, newDir, cssEntry.addr.fileName(), cssEntry.addr.blockOffset()+40, []byte{0xff, 0xff, 0xff, 0xff})
+
+	// slot of the bucket of the entry in the old cache
+	png := "https://golang.org/doc/gopher/pkg.png"
+	pngEntry, err := oldCache.OpenURL(png)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot := int(pngEntry.Hash & uint32(len(oldCache.indexTable())-1))
+	writeAt(t, oldDir, "index", int64(indexHeaderSize+4*slot), make([]byte, 4))
+	_ = oldCache.Close()
+
+	oldCache, err = OpenCache(oldDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oldCache.Close()
+	newCache, err := OpenCache(newDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newCache.Close()
+
+	diffs := Diff(oldCache, newCache)
+	want := map[string]Difference{
+		jquery: {Type: EntryUpdated, URL: jquery, Old: entry.addr, New: entry.addr, Fields: []string{"created"}},
+		png:    {Type: EntryAdded, URL: png, New: pngEntry.addr},
+		css:    {Type: EntryUpdated, URL: css, Old: cssEntry.addr, New: cssEntry.addr},
+	}
+	if len(diffs) < len(want) || !slices.IsSortedFunc(diffs, func(a, b Difference) int {
+		return strings.Compare(a.URL, b.URL)
+	}) {
+		t.Fatalf("bad diffs: %v", diffs)
+	}
+	for _, diff := range diffs {
+		w, ok := want[diff.URL]
+		if !ok && diff.Type == EntryAdded {
+			// in the same bucket as png
+			continue
+		}
+		if diff.Type != w.Type || diff.Old != w.Old || diff.New != w.New || !slices.Equal(diff.Fields, w.Fields) ||
+			(diff.Err != nil) != (diff.URL == css) {
+			t.Fatalf("%s: %+v, want: %+v", diff.URL, diff, w)
+		}
+	}
+
+	// selected before being compared
+	diffs = DiffQuery(oldCache, newCache, &Query{Host: "ajax.googleapis.com"})
+	if len(diffs) != 1 || diffs[0].URL != jquery || diffs[0].Err != nil {
+		t.Fatalf("query diffs: %+v, want: %s", diffs, jquery)
+	}
+
+	// only the unreadable entry in the same cache
+	diffs = Diff(newCache, newCache)
+	if len(diffs) != 1 || diffs[0].URL != css || diffs[0].Err == nil {
+		t.Fatalf("same cache: %+v", diffs)
+	}
+}
@@ -0,0 +1,235 @@
+package cdc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"time"
+)
+
+// EntryData holds all the data of an entry, as read from a cache
+// to be written into another one.
+type EntryData struct {
+	Key string
+
+	// Stream 0 holds the response info, stream 1 the body,
+	// stream 2 the metadata of the renderer, and stream 3 is unused.
+	Streams [4][]byte
+
+	// Sparse data of the entry, by increasing offset.
+	Sparse []SparseRange
+
+	Created      time.Time
+	LastUsed     time.Time
+	LastModified time.Time
+	ReuseCount   int32 // How often the entry was used.
+	RefetchCount int32 // How often the entry was fetched from the net.
+}
+
+// SparseRange is a range of the sparse data of an entry.
+type SparseRange struct {
+	Offset int64
+	Data   []byte
+}
+
+// ResponseInfo returns the HTTP response info of stream 0.
+func (d *EntryData) ResponseInfo() (*ResponseInfo, error) {
+	return parseResponseInfo(d.Streams[0])
+}
+
+// Child reports whether the entry stores a range of the sparse data
+// of another entry. The child entries are read with their parent.
+func (e *Entry) Child() bool {
+	return e.Flags&childEntry != 0
+}
+
+// EntryData reads all the data of the entry: its key, times, streams,
+// and its sparse data, read from its child entries.
+func (c *Cache) EntryData(entry *Entry) (*EntryData, error) {
+	rankings, err := entry.Rankings()
+	if err != nil {
+		return nil, err
+	}
+
+	data := EntryData{
+		Key:          entry.URL(),
+		Created:      entry.Created(),
+		LastUsed:     rankings.LastUsed,
+		LastModified: rankings.LastModified,
+		ReuseCount:   entry.ReuseCount,
+		RefetchCount: entry.RefetchCount,
+	}
+
+	for i := range data.Streams {
+		data.Streams[i], err = readStream(entry, i)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if entry.Flags&parentEntry != 0 {
+		// stream 2 holds the children of the sparse data
+		data.Sparse, err = c.readSparse(entry, data.Streams[2])
+		if err != nil {
+			return nil, &EntryError{Op: "stream", Addr: entry.addr, Err: err}
+		}
+		data.Streams[2] = nil
+	}
+	return &data, nil
+}
+
+// ReadEntries returns an iterator over the data of all the entries of the
+// cache in dir, in the blockfile or in the simple format. An entry which
+// could not be read is yielded as an error, the iteration continues with
+// the next entry.
+func ReadEntries(dir string) iter.Seq2[*EntryData, error] {
+	return func(yield func(*EntryData, error) bool) {
+		format, err := DetectFormat(dir)
+		if err != nil {
+			yield(nil, fmt.Errorf("read entries: %w", err))
+			return
+		}
+		if format == Simple {
+			for data, err := range simpleEntries(dir) {
+				if !yield(data, err) {
+					return
+				}
+			}
+			return
+		}
+
+		cache, err := OpenCacheWithOptions(dir, &Options{Lazy: true})
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer cache.Close()
+
+		for entry, err := range cache.Entries() {
+			if err == nil && entry.Child() {
+				continue
+			}
+			var data *EntryData
+			if err == nil {
+				data, err = cache.EntryData(entry)
+			}
+			if !yield(data, err) {
+				return
+			}
+		}
+	}
+}
+
+// readStream reads the stream index of entry.
+func readStream(entry *Entry, index int) ([]byte, error) {
+	stream, err := entry.Stream(index)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	b, err := io.ReadAll(stream)
+	if err != nil {
+		return nil, &EntryError{Op: "stream", Addr: entry.addr, Err: err}
+	}
+	return b, nil
+}
+
+// readSparseHeader reads the sparse header of stream 2,
+// and returns the bitmap which follows it.
+func readSparseHeader(b []byte) (*sparseHeader, []byte, error) {
+	var header sparseHeader
+	err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read sparse header: %w", ErrMalformed)
+	}
+	if header.Magic != magicNumber {
+		return nil, nil, fmt.Errorf("sparse magic: %x, want: %x: %w",
+			header.Magic, magicNumber, ErrMalformed)
+	}
+	return &header, b[sparseHeaderSize:], nil
+}
+
+// childKey returns the key of the child entry storing the range
+// [child*sparseChildSize, (child+1)*sparseChildSize) of the sparse
+// data of the entry key.
+func childKey(key string, signature int64, child int) string {
+	return fmt.Sprintf("Range_%s:%x:%x", key, uint64(signature), child)
+}
+
+// readSparse reads the sparse data of the entry from its children,
+// listed by the bitmap following the sparse header b.
+func (c *Cache) readSparse(entry *Entry, b []byte) ([]SparseRange, error) {
+	header, children, err := readSparseHeader(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []SparseRange
+	for child := range len(children) * 8 {
+		if children[child/8]&(1<<(child%8)) == 0 {
+			continue
+		}
+		e, err := c.OpenURL(childKey(entry.URL(), header.Signature, child))
+		if err != nil {
+			return nil, fmt.Errorf("sparse child %d: %w", child, err)
+		}
+		r, err := readChild(e, int64(child)*sparseChildSize)
+		if err != nil {
+			return nil, fmt.Errorf("sparse child %d: %w", child, err)
+		}
+
+		// the ranges continuing over two children are merged
+		if n := len(ranges); n > 0 && len(r) > 0 &&
+			ranges[n-1].Offset+int64(len(ranges[n-1].Data)) == r[0].Offset {
+			ranges[n-1].Data = slices.Concat(ranges[n-1].Data, r[0].Data)
+			r = r[1:]
+		}
+		ranges = append(ranges, r...)
+	}
+	return ranges, nil
+}
+
+// readChild reads the ranges of sparse data stored by the child entry,
+// starting at offset. Stream 1 holds the data, and stream 2 the bitmap
+// of its blocks, the last one may be partially written.
+func readChild(entry *Entry, offset int64) ([]SparseRange, error) {
+	b, err := readStream(entry, 2)
+	if err != nil {
+		return nil, err
+	}
+	header, blocks, err := readSparseHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readStream(entry, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []SparseRange
+	for block := range int(sparseChildSize / sparseBlockSize) {
+		start := int64(block) * sparseBlockSize
+		n := int64(0)
+		if block/8 < len(blocks) && blocks[block/8]&(1<<(block%8)) != 0 {
+			n = sparseBlockSize
+		} else if int32(block) == header.LastBlock && header.LastBlockLen > 0 {
+			n = int64(header.LastBlockLen)
+		}
+		n = min(n, int64(len(data))-start)
+		if n <= 0 {
+			continue
+		}
+
+		if last := len(ranges) - 1; last >= 0 &&
+			ranges[last].Offset+int64(len(ranges[last].Data)) == offset+start {
+			ranges[last].Data = data[ranges[last].Offset-offset : start+n]
+		} else {
+			ranges = append(ranges, SparseRange{Offset: offset + start, Data: data[start : start+n]})
+		}
+	}
+	return ranges, nil
+}
@@ -0,0 +1,163 @@
+package cdc
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reasons of the Freshness of a response: how its lifetime was computed,
+// or why it must not be reused without validation.
+const (
+	ReasonMaxAge     = "max-age"     // Cache-Control max-age directive.
+	ReasonExpires    = "expires"     // Expires minus Date header.
+	ReasonHeuristic  = "heuristic"   // 10% of the time since Last-Modified.
+	ReasonNoLifetime = "no lifetime" // No explicit nor heuristic lifetime.
+	ReasonNoCache    = "no-cache"    // Always validated.
+	ReasonNoStore    = "no-store"    // Must not be stored.
+	ReasonVaryAll    = "vary *"      // Never matches a request.
+)
+
+// heuristicStatus are the status codes heuristically cacheable.
+var heuristicStatus = []int{200, 203, 204, 206, 300, 301, 308, 404, 405, 410, 414, 501}
+
+// Freshness is the freshness of a response, as stored in a private
+// cache, following RFC 9111.
+type Freshness struct {
+	Fresh    bool
+	TTL      time.Duration // Lifetime minus Age, negative if stale.
+	Lifetime time.Duration
+	Age      time.Duration
+	Reason   string
+
+	// Cacheable reports whether a private cache may store the response,
+	// for its directives, status code and lifetime.
+	Cacheable bool
+
+	// Directives of the Cache-Control header.
+	MustRevalidate bool
+	Immutable      bool
+	SharedMaxAge   bool // s-maxage, ignored by a private cache.
+
+	Vary []string // Header names of the Vary header, canonicalized.
+}
+
+// Freshness returns the freshness of the entry at time now.
+// The returned error is of type *EntryError.
+func (e *Entry) Freshness(now time.Time) (*Freshness, error) {
+	info, err := e.ResponseInfo()
+	if err != nil {
+		return nil, err
+	}
+	return info.Freshness(now), nil
+}
+
+// Freshness returns the freshness of the response at time now, from its
+// header and its request and response times.
+func (info *ResponseInfo) Freshness(now time.Time) *Freshness {
+	var f Freshness
+	cc := cacheControl(info.Header)
+	_, f.MustRevalidate = cc["must-revalidate"]
+	_, f.Immutable = cc["immutable"]
+	_, f.SharedMaxAge = cc["s-maxage"]
+	for _, v := range info.Header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				f.Vary = append(f.Vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	f.Age = info.currentAge(now)
+	date, err := http.ParseTime(info.Header.Get("Date"))
+	if err != nil {
+		date = info.ResponseTime
+	}
+	_, public := cc["public"]
+
+	// lifetime, RFC 9111 section 4.2.1
+	maxAge, hasMaxAge := deltaSeconds(cc["max-age"])
+	expires, hasExpires := info.Header["Expires"]
+	switch {
+	case hasMaxAge:
+		f.Lifetime, f.Reason = maxAge, ReasonMaxAge
+		f.Cacheable = true
+	case hasExpires:
+		// an invalid date, like 0, is in the past
+		if t, err := http.ParseTime(expires[0]); err == nil {
+			f.Lifetime = t.Sub(date)
+		}
+		f.Reason = ReasonExpires
+		f.Cacheable = true
+	default:
+		f.Reason = ReasonNoLifetime
+		heuristic := public || slices.Contains(heuristicStatus, info.StatusCode)
+		f.Cacheable = heuristic
+		if lastModified, err := http.ParseTime(info.Header.Get("Last-Modified")); err == nil && heuristic {
+			if d := date.Sub(lastModified); d > 0 {
+				f.Lifetime, f.Reason = d/10, ReasonHeuristic
+			}
+		}
+	}
+	f.Lifetime = max(f.Lifetime, 0)
+	f.TTL = f.Lifetime - f.Age
+	f.Fresh = f.TTL > 0
+
+	switch {
+	case hasDirective(cc, "no-store"):
+		f.Fresh, f.Cacheable, f.Reason = false, false, ReasonNoStore
+	case slices.Contains(f.Vary, "*"):
+		f.Fresh, f.Cacheable, f.Reason = false, false, ReasonVaryAll
+	case hasDirective(cc, "no-cache"):
+		f.Fresh, f.Cacheable, f.Reason = false, true, ReasonNoCache
+	}
+	return &f
+}
+
+// currentAge returns the age of the response at time now,
+// RFC 9111 section 4.2.3.
+func (info *ResponseInfo) currentAge(now time.Time) time.Duration {
+	ageValue, _ := deltaSeconds(info.Header.Get("Age"))
+	responseDelay := max(info.ResponseTime.Sub(info.RequestTime), 0)
+	correctedAge := ageValue + responseDelay
+
+	initialAge := correctedAge
+	if date, err := http.ParseTime(info.Header.Get("Date")); err == nil {
+		initialAge = max(info.ResponseTime.Sub(date), correctedAge)
+	}
+	return initialAge + max(now.Sub(info.ResponseTime), 0)
+}
+
+// cacheControl returns the directives of the Cache-Control header,
+// lowercased, with their unquoted argument.
+func cacheControl(header http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				cc[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+			}
+		}
+	}
+	return cc
+}
+
+// hasDirective reports whether the directive is in cc, with no argument
+// as the no-cache and private directives may list header names.
+func hasDirective(cc map[string]string, name string) bool {
+	arg, ok := cc[name]
+	return ok && arg == ""
+}
+
+// deltaSeconds parses a non-negative number of seconds.
+func deltaSeconds(s string) (time.Duration, bool) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	// larger values are 2^31, RFC 9111 section 1.2.2
+	return time.Duration(min(n, 1<<31)) * time.Second, true
+}
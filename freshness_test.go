@@ -0,0 +1,74 @@
+package cdc
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFreshness(t *testing.T) {
+	response := time.Date(2016, 1, 9, 23, 0, 0, 0, time.UTC)
+	date := response.Add(-10 * time.Second).Format(http.TimeFormat)
+	now := response.Add(time.Hour)
+
+	tests := []struct {
+		status int
+		header map[string]string
+		fresh  bool
+		ttl    time.Duration
+		reason string
+		cache  bool
+	}{
+		{200, map[string]string{"Date": date, "Cache-Control": "public, max-age=7200"},
+			true, time.Hour - 10*time.Second, ReasonMaxAge, true},
+		{200, map[string]string{"Date": date, "Cache-Control": "max-age=7200", "Age": "3600"},
+			false, 0, ReasonMaxAge, true}, // Age is larger than the apparent age
+		{200, map[string]string{"Date": date, "Cache-Control": "s-maxage=7200, max-age=60"},
+			false, time.Minute - time.Hour - 10*time.Second, ReasonMaxAge, true},
+		{200, map[string]string{"Date": date, "Expires": response.Add(2 * time.Hour).Format(http.TimeFormat)},
+			true, time.Hour, ReasonExpires, true},
+		{200, map[string]string{"Date": date, "Expires": "0"},
+			false, -time.Hour - 10*time.Second, ReasonExpires, true},
+		{200, map[string]string{"Date": date, "Last-Modified": response.Add(-100*24*time.Hour - 10*time.Second).Format(http.TimeFormat)},
+			true, 10*24*time.Hour - time.Hour - 10*time.Second, ReasonHeuristic, true},
+		{500, map[string]string{"Date": date, "Last-Modified": response.Add(-100 * 24 * time.Hour).Format(http.TimeFormat)},
+			false, -time.Hour - 10*time.Second, ReasonNoLifetime, false},
+		{200, map[string]string{"Date": date, "Cache-Control": "max-age=7200, no-cache"},
+			false, time.Hour - 10*time.Second, ReasonNoCache, true},
+		{200, map[string]string{"Date": date, "Cache-Control": `max-age=7200, no-cache="Set-Cookie"`},
+			true, time.Hour - 10*time.Second, ReasonMaxAge, true},
+		{200, map[string]string{"Date": date, "Cache-Control": "NO-STORE, max-age=7200"},
+			false, time.Hour - 10*time.Second, ReasonNoStore, false},
+		{200, map[string]string{"Date": date, "Cache-Control": "max-age=7200", "Vary": "*"},
+			false, time.Hour - 10*time.Second, ReasonVaryAll, false},
+	}
+
+	for _, tt := range tests {
+		info := ResponseInfo{
+			StatusCode:   tt.status,
+			RequestTime:  response,
+			ResponseTime: response,
+			Header:       make(http.Header),
+		}
+		for k, v := range tt.header {
+			info.Header.Set(k, v)
+		}
+
+		f := info.Freshness(now)
+		if f.Fresh != tt.fresh || f.TTL != tt.ttl || f.Reason != tt.reason || f.Cacheable != tt.cache {
+			t.Errorf("%d %v: fresh %t, ttl %v, reason %q, cacheable %t, want: %t, %v, %q, %t",
+				tt.status, tt.header, f.Fresh, f.TTL, f.Reason, f.Cacheable,
+				tt.fresh, tt.ttl, tt.reason, tt.cache)
+		}
+	}
+
+	info := ResponseInfo{Header: http.Header{
+		"Cache-Control": {"max-age=60, Must-Revalidate", "immutable"},
+		"Vary":          {"accept-encoding, User-Agent"},
+	}}
+	f := info.Freshness(now)
+	if !f.MustRevalidate || !f.Immutable || f.SharedMaxAge ||
+		len(f.Vary) != 2 || f.Vary[0] != "Accept-Encoding" || f.Vary[1] != "User-Agent" {
+		t.Errorf("directives: %+v", f)
+	}
+}
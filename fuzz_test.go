@@ -0,0 +1,141 @@
+package cdc
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+)
+
+// copyCache copies the files of testdata into a temporary directory.
+func copyCache(tb testing.TB) string {
+	dir := tb.TempDir()
+	files, err := os.ReadDir("testdata")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, file := range files {
+		b, err := os.ReadFile(path.Join("testdata", file.Name()))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		err = os.WriteFile(path.Join(dir, file.Name()), b, 0644)
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// tableAddrs returns the initialized addresses of the testdata index table.
+func tableAddrs(tb testing.TB) []Addr {
+	b, err := os.ReadFile(path.Join("testdata", "index"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var addrs []Addr
+	for i := indexHeaderSize; i+4 <= len(b); i += 4 {
+		addr := Addr(binary.LittleEndian.Uint32(b[i:]))
+		if addr.initialized() {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func FuzzOpenEntry(f *testing.F) {
+	for _, addr := range tableAddrs(f) {
+		block, err := readAddr(addr, dirReader("testdata"))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(uint32(addr), block)
+	}
+	dir := copyCache(f)
+
+	f.Fuzz(func(t *testing.T, addr uint32, block []byte) {
+		// the fuzzed block replaces the first blocks of data_1
+		name := path.Join(dir, "data_1")
+		file, err := os.OpenFile(name, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = file.WriteAt(block, int64(blockHeaderSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = file.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		entry, err := OpenEntry(Addr(addr), dir)
+		if err != nil {
+			return
+		}
+		_ = entry.URL()
+		_, _ = entry.Header()
+		if body, err := entry.Body(); err == nil {
+			_ = body.Close()
+		}
+	})
+}
+
+func FuzzHeader(f *testing.F) {
+	for _, addr := range tableAddrs(f) {
+		entry, err := OpenEntry(addr, "testdata")
+		if err != nil {
+			f.Fatal(err)
+		}
+		b, err := readAddrSize(entry.DataAddr[0], "testdata", entry.DataSize[0])
+		if err != nil {
+			continue
+		}
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		_, _ = parseResponseInfo(b)
+	})
+}
+
+func FuzzOpenCache(f *testing.F) {
+	b, err := os.ReadFile(path.Join("testdata", "index"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(b[:indexHeaderSize])
+
+	// the full table is too large to be mutated efficiently,
+	// seed a compact one with the same entries.
+	addrs := tableAddrs(f)
+	index := make([]byte, indexHeaderSize, indexHeaderSize+4*len(addrs))
+	copy(index, b)
+	binary.LittleEndian.PutUint32(index[28:], uint32(len(addrs))) // TableLen
+	for _, addr := range addrs {
+		index = binary.LittleEndian.AppendUint32(index, uint32(addr))
+	}
+	f.Add(index)
+
+	dir := copyCache(f)
+
+	f.Fuzz(func(t *testing.T, index []byte) {
+		err := os.WriteFile(path.Join(dir, "index"), index, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cache, err := OpenCache(dir)
+		if err != nil {
+			return
+		}
+		defer cache.Close()
+		for _, url := range cache.URLs() {
+			entry, err := cache.OpenURL(url)
+			if err != nil {
+				continue
+			}
+			_, _ = entry.Header()
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package cdc
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Sum is the SHA-256 hash of a body.
+type Sum [sha256.Size]byte
+
+// String returns the hash in lowercase hexadecimal.
+func (s Sum) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// BodyHash is the hash of the body of an entry, as stored and decoded
+// according to the Content-Encoding header. Decoded equals Raw if the body
+// is not encoded.
+type BodyHash struct {
+	Raw     Sum
+	Decoded Sum
+
+	// The MD5 and SHA-1 hashes, as listed by the NSRL.
+	RawMD5, DecodedMD5   [md5.Size]byte
+	RawSHA1, DecodedSHA1 [sha1.Size]byte
+
+	// DecodeErr is the error decoding the body, the decoded hashes
+	// are then zero.
+	DecodeErr error
+}
+
+// BodyHash reads the body of the entry once to hash it, as stored and
+// decoded. The body is hashed as stored even if it can not be decoded.
+func (e *Entry) BodyHash() (*BodyHash, error) {
+	header, err := e.Header()
+	if err != nil {
+		return nil, err
+	}
+	body, err := e.Body()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var h BodyHash
+	raw := []hash.Hash{sha256.New(), md5.New(), sha1.New()}
+	tee := io.TeeReader(body, io.MultiWriter(raw[0], raw[1], raw[2]))
+
+	decoded, err := decodeBody(io.NopCloser(tee), header.Get("Content-Encoding"))
+	if err == nil {
+		hashes := []hash.Hash{sha256.New(), md5.New(), sha1.New()}
+		_, err = io.Copy(io.MultiWriter(hashes[0], hashes[1], hashes[2]), decoded)
+		_ = decoded.Close()
+		if err == nil {
+			hashes[0].Sum(h.Decoded[:0])
+			hashes[1].Sum(h.DecodedMD5[:0])
+			hashes[2].Sum(h.DecodedSHA1[:0])
+		}
+	}
+	if err != nil {
+		h.DecodeErr = &EntryError{Op: "body", Addr: e.addr, Err: err}
+	}
+
+	// the rest of the body, not read by the decoders
+	_, err = io.Copy(io.Discard, tee)
+	if err != nil {
+		return nil, &EntryError{Op: "body", Addr: e.addr, Err: err}
+	}
+	raw[0].Sum(h.Raw[:0])
+	raw[1].Sum(h.RawMD5[:0])
+	raw[2].Sum(h.RawSHA1[:0])
+	return &h, nil
+}
+
+// ParseSum parses a SHA-256 hash in hexadecimal, in any case.
+func ParseSum(s string) (Sum, error) {
+	var sum Sum
+	if hex.DecodedLen(len(s)) != len(sum) {
+		return sum, fmt.Errorf("hash %q: want %d hexadecimal digits", s, hex.EncodedLen(len(sum)))
+	}
+	_, err := hex.Decode(sum[:], []byte(s))
+	if err != nil {
+		return sum, fmt.Errorf("hash %q: %w", s, err)
+	}
+	return sum, nil
+}
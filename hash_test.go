@@ -0,0 +1,99 @@
+package cdc
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBodyHash(t *testing.T) {
+	cache, err := OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	for entry, err := range cache.Entries() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := entry.BodyHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.DecodeErr != nil {
+			t.Errorf("%s: %v", entry.URL(), h.DecodeErr)
+			continue
+		}
+
+		raw, err := entry.bodySum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Raw != raw {
+			t.Errorf("%s: raw hash %s, want: %x", entry.URL(), h.Raw, raw)
+		}
+
+		stored, err := entry.Body()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(stored)
+		_ = stored.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.RawMD5 != md5.Sum(b) || h.RawSHA1 != sha1.Sum(b) {
+			t.Errorf("%s: raw MD5 %x, SHA-1 %x", entry.URL(), h.RawMD5, h.RawSHA1)
+		}
+
+		body, err := entry.DecodedBody()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err = io.ReadAll(body)
+		_ = body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded := Sum(sha256.Sum256(b)); h.Decoded != decoded {
+			t.Errorf("%s: decoded hash %s, want: %s", entry.URL(), h.Decoded, decoded)
+		}
+		if h.DecodedMD5 != md5.Sum(b) || h.DecodedSHA1 != sha1.Sum(b) {
+			t.Errorf("%s: decoded MD5 %x, SHA-1 %x", entry.URL(), h.DecodedMD5, h.DecodedSHA1)
+		}
+
+		header, err := entry.Header()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch encoding := strings.ToLower(header.Get("Content-Encoding")); encoding {
+		case "", "identity":
+			if h.Decoded != h.Raw {
+				t.Errorf("%s: decoded hash %s, want the raw hash %s", entry.URL(), h.Decoded, h.Raw)
+			}
+		case "gzip", "x-gzip", "deflate", "br":
+			if h.Decoded == h.Raw {
+				t.Errorf("%s: %s decoded hash equals raw hash", entry.URL(), encoding)
+			}
+		}
+	}
+}
+
+func TestParseSum(t *testing.T) {
+	want := Sum(sha256.Sum256([]byte("cdc")))
+	for _, s := range []string{want.String(), strings.ToUpper(want.String())} {
+		sum, err := ParseSum(s)
+		if err != nil || sum != want {
+			t.Errorf("ParseSum(%q): %s, %v, want: %s", s, sum, err, want)
+		}
+	}
+	for _, s := range []string{"", want.String()[2:], "zz" + want.String()[2:]} {
+		if _, err := ParseSum(s); err == nil {
+			t.Errorf("ParseSum(%q): no error", s)
+		}
+	}
+}
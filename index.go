@@ -3,10 +3,14 @@ package cdc
 import (
 	"encoding/binary"
 	"fmt"
-	"log"
+	"iter"
+	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
 )
 
 // Cache gives read access to the chromium disk cache.
@@ -14,33 +18,175 @@ import (
 // The cache is composed of one "index" file, four or more "data_[0-9]" files
 // and many of "f_[0-9]+" separate files.
 //
+// The block files are kept open until the cache is closed.
+// A Cache is safe for concurrent use by multiple goroutines.
+//
 // Learn more:
 // http://www.forensicswiki.org/wiki/Google_Chrome#Disk_Cache
 // http://www.forensicswiki.org/wiki/Chrome_Disk_Cache_Format
 type Cache struct {
-	dir  string          // cache directory
-	addr map[uint32]Addr // [entry.hash]addr
-	urls []string        // []entry.key
+	dir    string      // cache directory
+	blocks string      // directory of the index and block files, dir or its snapshot
+	files  *blockFiles // open block files
+	log    *slog.Logger
+	opts   Options
+
+	// The fields below are replaced, not modified, by Reload.
+	mu      sync.RWMutex
+	table   []Addr          // index table
+	addr    map[uint32]Addr // [entry.hash]addr
+	urls    []string        // []entry.key
+	buckets []bucket        // entries of the table, once loaded
+	stamps  []fileStamp     // index and block files, once loaded
+
+	once     sync.Once  // loads addr and urls in lazy mode
+	reloadMu sync.Mutex // serializes Reload
+
+	// thisID is the id of the session of the index, stored as the dirty
+	// flag of the entries open in the session. Set by Reload.
+	thisID atomic.Int32
+}
+
+// Options configures how a cache is opened.
+type Options struct {
+	// Logger receives the entries which could not be read
+	// or which are skipped. If nil, nothing is logged.
+	Logger *slog.Logger
+
+	// Strict makes OpenCacheWithOptions fail on the first entry
+	// which could not be read, instead of skipping it.
+	Strict bool
+
+	// Lazy only reads the index table when the cache is opened.
+	// Entries are read when they are looked up, and all of them
+	// the first time URLs is called.
+	Lazy bool
+
+	// Workers is the number of goroutines reading the entries
+	// when the cache is opened. Zero means one.
+	Workers int
+
+	// Mmap maps the block files in memory, read-only, instead of
+	// reading them with system calls. It is ignored on systems not
+	// supporting mmap. The block files must not be truncated while
+	// they are mapped, so do not use it on the cache of a running browser,
+	// unless with Snapshot.
+	Mmap bool
+
+	// Snapshot copies the index and the block files into a temporary
+	// directory when the cache is opened, and reads the copy, so the cache
+	// of a running browser can be read consistently. The separate files
+	// are read from the cache directory. The entries of the copy failing
+	// their self hash or marked dirty are copied again from the cache, and
+	// reported by Inconsistent if they are still not consistent.
+	// Reload does not see the changes made after the copy.
+	Snapshot bool
+}
+
+// Close closes the block files of the cache, and removes its snapshot.
+// Entries opened from the cache can not be read afterwards.
+func (c *Cache) Close() error {
+	err := c.files.close()
+	if c.blocks != c.dir {
+		if e := os.RemoveAll(c.blocks); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
 }
 
 // URLs returns all the URLs currently stored.
 func (c *Cache) URLs() []string {
-	urls := make([]string, len(c.urls))
-	copy(urls, c.urls)
-	return urls
+	c.load()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Clone(c.urls)
 }
 
 // GetAddr returns the address of the URL.
 // An error is returned if the URL is not found.
 func (c *Cache) GetAddr(url string) (Addr, error) {
 	hash := superFastHash([]byte(url))
+	if c.opts.Lazy {
+		return c.lookup(url, hash)
+	}
+	c.mu.RLock()
 	addr, ok := c.addr[hash]
+	c.mu.RUnlock()
 	if !ok {
 		return addr, ErrNotFound
 	}
 	return addr, nil
 }
 
+// indexTable returns the current index table.
+func (c *Cache) indexTable() []Addr {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.table
+}
+
+// lookup follows the chain of entries of the bucket of hash
+// until the entry of url is found.
+func (c *Cache) lookup(url string, hash uint32) (Addr, error) {
+	table := c.indexTable()
+	if len(table) == 0 {
+		return 0, ErrNotFound
+	}
+
+	addr := table[hash&uint32(len(table)-1)]
+	for entry, err := range c.chain(addr) {
+		if err != nil {
+			return 0, err
+		}
+		if entry.Hash == hash && entry.State == 0 && entry.URL() == url {
+			return entry.addr, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+// Entries returns an iterator over the entries of the cache, in index order.
+// The entries are read from the block files as the iteration goes.
+// An entry which could not be read is yielded as an error, the iteration
+// continues with the next bucket of the table.
+func (c *Cache) Entries() iter.Seq2[*Entry, error] {
+	return func(yield func(*Entry, error) bool) {
+		for _, addr := range c.indexTable() {
+			for entry, err := range c.chain(addr) {
+				if err == nil && (entry.State != 0 || entry.truncatedKey()) {
+					continue
+				}
+				if !yield(entry, err) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// chain returns an iterator over the entries chained from addr,
+// whatever their state. It stops after yielding the first error.
+func (c *Cache) chain(addr Addr) iter.Seq2[*Entry, error] {
+	return func(yield func(*Entry, error) bool) {
+		var seen []Addr
+
+		for addr.initialized() && !slices.Contains(seen, addr) {
+			seen = append(seen, addr)
+
+			entry, err := c.openEntry(addr)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(entry, nil) {
+				return
+			}
+			addr = entry.Next
+		}
+	}
+}
+
 // OpenURL returns the Entry for the specified URL.
 // An error is returned if the URL is not found.
 func (c *Cache) OpenURL(url string) (*Entry, error) {
@@ -48,70 +194,285 @@ func (c *Cache) OpenURL(url string) (*Entry, error) {
 	if err != nil {
 		return nil, err
 	}
-	entry, err := OpenEntry(addr, c.dir)
+	entry, err := c.openEntry(addr)
 	if err != nil {
-		return nil, fmt.Errorf("open url %s: %v", url, err)
+		return nil, fmt.Errorf("open url %s: %w", url, err)
 	}
 	return entry, nil
 }
 
+// OpenEntry returns the Entry at the specified address,
+// reading it from the block files of the cache.
+func (c *Cache) OpenEntry(addr Addr) (*Entry, error) {
+	return c.openEntry(addr)
+}
+
 // OpenCache opens the cache in dir.
 // Opens the "index" file to read the addresses and then
 // opens each Entry to read the URL and associate it to an address.
+// Entries which could not be read are skipped silently.
 func OpenCache(dir string) (*Cache, error) {
+	return OpenCacheWithOptions(dir, nil)
+}
+
+// OpenCacheWithOptions opens the cache in dir as OpenCache does,
+// with the specified options. A nil opts is the same as the zero Options.
+func OpenCacheWithOptions(dir string, opts *Options) (*Cache, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
 	err := checkCache(dir)
 	if err != nil {
-		return nil, fmt.Errorf("invalid cache: %s, %v", dir, err)
+		return nil, fmt.Errorf("invalid cache: %s, %w", dir, err)
+	}
+
+	dir = filepath.Clean(dir)
+	blocks := dir
+	if opts.Snapshot {
+		blocks, err = snapshot(dir, logger)
+		if err != nil {
+			return nil, fmt.Errorf("open cache: %w", err)
+		}
+	}
+
+	cache := Cache{
+		dir:    dir,
+		blocks: blocks,
+		files:  newBlockFiles(blocks, opts.Mmap),
+		log:    logger,
+		opts:   *opts,
+	}
+	cache.files.separate = dir
+
+	index, table, err := readIndex(blocks)
+	if err != nil {
+		_ = cache.Close()
+		return nil, fmt.Errorf("open cache: %w", err)
+	}
+	cache.table = table
+	cache.thisID.Store(index.ThisID)
+
+	if !opts.Lazy {
+		var err error
+		cache.once.Do(func() { err = cache.readTable(opts.Strict) })
+		if err != nil {
+			_ = cache.Close()
+			return nil, fmt.Errorf("open cache: %w", err)
+		}
 	}
+	return &cache, nil
+}
 
+// readIndex reads the header and the table of the index file of dir.
+func readIndex(dir string) (*indexHeader, []Addr, error) {
 	file, err := os.Open(path.Join(dir, "index"))
 	if err != nil {
-		return nil, fmt.Errorf("open cache: %v", err)
+		return nil, nil, err
 	}
-	defer close(file)
+	defer file.Close()
 
 	var index indexHeader
 	err = binary.Read(file, binary.LittleEndian, &index)
 	if err != nil {
-		return nil, fmt.Errorf("open cache: %v", err)
+		return nil, nil, err
 	}
 	if index.Magic != magicNumber {
-		return nil, fmt.Errorf("magic: %x, want: %x",
-			index.Magic, magicNumber)
+		return nil, nil, fmt.Errorf("magic: %x, want: %x: %w",
+			index.Magic, magicNumber, ErrMalformed)
 	}
 
-	cache := Cache{
-		dir:  filepath.Dir(file.Name()),
-		addr: make(map[uint32]Addr, index.NumEntries),
-		urls: make([]string, 0, index.NumEntries),
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	var addr Addr
-	for i := index.TableLen; i > 0; i-- {
-		err = binary.Read(file, binary.LittleEndian, &addr)
-		if err != nil {
-			return nil, fmt.Errorf("open cache: %v", err)
+	tableLen := index.TableLen
+	if tableLen == 0 {
+		tableLen = indexTableSize
+	}
+	maxLen := (info.Size() - int64(indexHeaderSize)) / 4
+	if tableLen < 0 || int64(tableLen) > maxLen {
+		return nil, nil, fmt.Errorf("table length %d out of range: %w",
+			tableLen, ErrMalformed)
+	}
+
+	table := make([]Addr, tableLen)
+	err = binary.Read(file, binary.LittleEndian, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &index, table, nil
+}
+
+// load reads all the entries of the table if not done yet.
+func (c *Cache) load() {
+	c.once.Do(func() { _ = c.readTable(false) })
+}
+
+// bucket holds the entries chained from one address of the table.
+type bucket struct {
+	entries []bucketEntry
+	chain   []chainLink // all the entries of the chain, whatever their state
+	err     error
+}
+
+// chainLink is an entry of the chain of a bucket, with the hash of its
+// block telling whether it was written since the bucket was read.
+type chainLink struct {
+	addr Addr
+	sum  uint32 // superFastHash of the entryStore
+}
+
+type bucketEntry struct {
+	addr  Addr
+	hash  uint32
+	url   string
+	store entryStore // compared by Reload
+}
+
+// readTable reads the entries of the table to associate their URL
+// to their address. The URLs are kept in index order.
+// If strict is false, the entries which could not be read are skipped.
+func (c *Cache) readTable(strict bool) error {
+	// stamped first, so changes made while reading are seen by Reload
+	stamps, err := statFiles(c.blocks)
+	if err != nil {
+		return err
+	}
+
+	table := c.indexTable()
+	buckets, err := c.readBuckets(table, strict, nil)
+	if err != nil {
+		return err
+	}
+	c.setBuckets(table, buckets, stamps)
+	return nil
+}
+
+// readBuckets reads the buckets of the table with a pool of workers.
+// The bucket returned by reuse is kept instead of being read, if any.
+// If strict is false, the errors are logged and the buckets keep
+// the entries read before the error.
+func (c *Cache) readBuckets(table []Addr, strict bool, reuse func(i int) (bucket, bool)) ([]bucket, error) {
+	buckets := make([]bucket, len(table))
+	next := make(chan int)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	var wg sync.WaitGroup
+
+	for range max(c.opts.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if reuse != nil {
+					if b, ok := reuse(i); ok {
+						buckets[i] = b
+						continue
+					}
+				}
+				buckets[i] = c.readBucket(table[i])
+				if strict && buckets[i].err != nil {
+					stopOnce.Do(func() { close(stop) })
+				}
+			}
+		}()
+	}
+
+feed:
+	for i, addr := range table {
+		if !addr.initialized() {
+			continue
 		}
-		if addr.initialized() {
-			cache.readAddr(addr)
+		select {
+		case next <- i:
+		case <-stop:
+			break feed
 		}
 	}
-	return &cache, nil
+	close(next)
+	wg.Wait()
+
+	for _, b := range buckets {
+		if b.err != nil {
+			if strict {
+				return nil, b.err
+			}
+			c.log.Warn("open cache: skip entry", "err", b.err)
+		}
+	}
+	return buckets, nil
 }
 
-func (c *Cache) readAddr(addr Addr) {
-	entry, err := OpenEntry(addr, c.dir)
-	if err != nil {
-		log.Printf("open cache: %v", err)
-		return
+// setBuckets replaces the table and the entries of the cache.
+func (c *Cache) setBuckets(table []Addr, buckets []bucket, stamps []fileStamp) {
+	addr := make(map[uint32]Addr)
+	var urls []string
+	for _, b := range buckets {
+		for _, e := range b.entries {
+			addr[e.hash] = e.addr
+			urls = append(urls, e.url)
+		}
 	}
-	if entry.State == 0 &&
-		// KeyLen may be larger, not managed
-		entry.KeyLen <= blockKeyLen {
 
-		c.addr[entry.Hash] = addr
-		c.urls = append(c.urls, entry.URL())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.table, c.buckets, c.stamps = table, buckets, stamps
+	c.addr, c.urls = addr, urls
+}
+
+// readBucket reads the entries chained from addr.
+// It stops on the first entry which could not be read.
+func (c *Cache) readBucket(addr Addr) bucket {
+	var b bucket
+
+	for entry, err := range c.chain(addr) {
+		if err != nil {
+			b.err = err
+			break
+		}
+		store, err := binary.Append(nil, binary.LittleEndian, entry.entryStore)
+		if err != nil {
+			b.err = err
+			break
+		}
+		b.chain = append(b.chain, chainLink{addr: entry.addr, sum: superFastHash(store)})
+
+		if entry.State != 0 {
+			c.log.Debug("open cache: skip entry", "addr", entry.addr, "state", entry.State)
+		} else if entry.truncatedKey() {
+			c.log.Debug("open cache: skip entry", "addr", entry.addr, "keylen", entry.KeyLen)
+		} else {
+			b.entries = append(b.entries, bucketEntry{
+				addr:  entry.addr,
+				hash:  entry.Hash,
+				url:   entry.URL(),
+				store: *entry.entryStore,
+			})
+		}
+	}
+	return b
+}
+
+// sameChain reports whether the entries of the chain of b are unchanged
+// in the block files, so b can be kept without reading them again.
+func (c *Cache) sameChain(b bucket) bool {
+	if b.err != nil || len(b.chain) == 0 {
+		return false
+	}
+	for _, link := range b.chain {
+		block, err := readAddr(link.addr, c.files)
+		if err != nil || len(block) < entryStoreSize ||
+			superFastHash(block[:entryStoreSize]) != link.sum {
+			return false
+		}
 	}
+	return true
 }
 
 func checkCache(dir string) error {
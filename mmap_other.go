@@ -0,0 +1,17 @@
+//go:build !unix
+
+package cdc
+
+import (
+	"errors"
+	"os"
+)
+
+// mmap is not supported, the block files are read with ReadAt.
+func mmap(file *os.File) ([]byte, error) {
+	return nil, nil
+}
+
+func munmap(data []byte) error {
+	return errors.ErrUnsupported
+}
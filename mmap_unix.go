@@ -0,0 +1,29 @@
+//go:build unix
+
+package cdc
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap maps the content of file in memory, read-only.
+// An empty file is not mapped.
+func mmap(file *os.File) ([]byte, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, nil
+	}
+	if int64(int(size)) != size {
+		return nil, syscall.EFBIG
+	}
+	return syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
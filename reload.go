@@ -0,0 +1,218 @@
+package cdc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// EventType is the type of a change of an entry.
+type EventType int
+
+// The types of the changes of the entries.
+const (
+	EntryAdded EventType = iota + 1
+	EntryRemoved
+	EntryUpdated
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EntryAdded:
+		return "added"
+	case EntryRemoved:
+		return "removed"
+	case EntryUpdated:
+		return "updated"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event is a change of an entry, found by Reload.
+type Event struct {
+	Type EventType
+	Addr Addr
+	URL  string
+}
+
+// fileStamp identifies a version of a file of the cache.
+type fileStamp struct {
+	name    string
+	size    int64
+	modTime int64 // in nanoseconds
+}
+
+// statFiles returns the stamps of the index and of the block files of dir,
+// sorted by name.
+func statFiles(dir string) ([]fileStamp, error) {
+	// ignore err as the only possible returned error is filepath.ErrBadPattern
+	names, _ := filepath.Glob(path.Join(dir, "data_[0-9]*"))
+	names = append(names, path.Join(dir, "index"))
+	slices.Sort(names)
+
+	stamps := make([]fileStamp, 0, len(names))
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{
+			name:    filepath.Base(name),
+			size:    info.Size(),
+			modTime: info.ModTime().UnixNano(),
+		})
+	}
+	return stamps, nil
+}
+
+// Reload reads the changes made to the cache since it was opened or
+// last reloaded, and returns them as events: the entries added, removed,
+// or updated in place. Nothing is read if the index and the block files
+// have not changed. Otherwise the index table is read again, and only its
+// changed buckets are read: the buckets of the changed slots, and if the
+// block files have changed, the buckets with an entry written since.
+//
+// A bucket which could not be read keeps its previous entries,
+// as the browser may be writing it.
+func (c *Cache) Reload() ([]Event, error) {
+	c.load()
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	stamps, err := statFiles(c.blocks)
+	if err != nil {
+		return nil, fmt.Errorf("reload: %w", err)
+	}
+
+	c.mu.RLock()
+	oldTable, oldBuckets, oldStamps := c.table, c.buckets, c.stamps
+	c.mu.RUnlock()
+
+	if slices.Equal(stamps, oldStamps) {
+		return nil, nil
+	}
+	blocksChanged := !slices.EqualFunc(stamps, oldStamps, func(a, b fileStamp) bool {
+		return a == b || a.name == "index"
+	})
+
+	if blocksChanged {
+		err = c.files.refresh()
+		if err != nil {
+			return nil, fmt.Errorf("reload: %w", err)
+		}
+	}
+
+	index, table, err := readIndex(c.blocks)
+	if err != nil {
+		return nil, fmt.Errorf("reload: %w", err)
+	}
+	c.thisID.Store(index.ThisID)
+
+	unchanged := func(i int) bool {
+		return i < len(oldTable) && oldTable[i] == table[i]
+	}
+	buckets, err := c.readBuckets(table, false, func(i int) (bucket, bool) {
+		if !unchanged(i) {
+			return bucket{}, false
+		}
+		// the block files are written for any entry of the cache
+		if !blocksChanged || c.sameChain(oldBuckets[i]) {
+			return oldBuckets[i], true
+		}
+		return bucket{}, false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reload: %w", err)
+	}
+	for i := range buckets {
+		if buckets[i].err != nil && unchanged(i) {
+			buckets[i] = oldBuckets[i]
+		}
+	}
+
+	c.setBuckets(table, buckets, stamps)
+	return diffBuckets(oldBuckets, buckets), nil
+}
+
+// diffBuckets returns the events changing the entries of old into new,
+// in index order.
+func diffBuckets(old, new []bucket) []Event {
+	before := make(map[Addr]*bucketEntry)
+	for i := range old {
+		for j := range old[i].entries {
+			e := &old[i].entries[j]
+			before[e.addr] = e
+		}
+	}
+
+	var events []Event
+	after := make(map[Addr]bool)
+	for i := range new {
+		for _, e := range new[i].entries {
+			after[e.addr] = true
+			prev, ok := before[e.addr]
+
+			switch {
+			case !ok:
+				events = append(events, Event{Type: EntryAdded, Addr: e.addr, URL: e.url})
+
+			case prev.url != e.url:
+				// the address was reused by another entry
+				events = append(events,
+					Event{Type: EntryRemoved, Addr: prev.addr, URL: prev.url},
+					Event{Type: EntryAdded, Addr: e.addr, URL: e.url})
+
+			case prev.store != e.store:
+				events = append(events, Event{Type: EntryUpdated, Addr: e.addr, URL: e.url})
+			}
+		}
+	}
+
+	for i := range old {
+		for _, e := range old[i].entries {
+			if !after[e.addr] {
+				events = append(events, Event{Type: EntryRemoved, Addr: e.addr, URL: e.url})
+			}
+		}
+	}
+	return events
+}
+
+// Watch reloads the cache every interval and sends the events of Reload
+// on the returned channel, until ctx is done and the channel is closed.
+// The errors of Reload are logged.
+func (c *Cache) Watch(ctx context.Context, interval time.Duration) <-chan Event {
+	events := make(chan Event, 64)
+
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			changes, err := c.Reload()
+			if err != nil {
+				c.log.Warn("watch cache", "err", err)
+				continue
+			}
+			for _, event := range changes {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events
+}
@@ -0,0 +1,206 @@
+package cdc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeAt writes b at offset in the file name of dir,
+// and moves its modification time forward.
+func writeAt(tb testing.TB, dir, name string, offset int64, b []byte) {
+	file, err := os.OpenFile(path.Join(dir, name), os.O_WRONLY, 0)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+
+	_, err = file.WriteAt(b, offset)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	modTime := info.ModTime().Add(time.Second)
+	err = os.Chtimes(file.Name(), modTime, modTime)
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func eventTypes(events []Event, url string) []EventType {
+	var types []EventType
+	for _, event := range events {
+		if event.URL == url {
+			types = append(types, event.Type)
+		}
+	}
+	return types
+}
+
+func TestReload(t *testing.T) {
+	dir := copyCache(t)
+	cache, err := OpenCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	jquery := "https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js"
+	entry, err := cache.OpenURL(jquery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := len(cache.URLs())
+
+	events, err := cache.Reload()
+	if err != nil || len(events) != 0 {
+		t.Fatalf("unchanged: %v, %v", events, err)
+	}
+
+	// ReuseCount of the entry
+	reuse := make([]byte, 4)
+	binary.LittleEndian.PutUint32(reuse, uint32(entry.ReuseCount+1))
+	writeAt(t, dir, entry.addr.fileName(), entry.addr.blockOffset()+12, reuse)
+
+	events, err = cache.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0] != (Event{Type: EntryUpdated, Addr: entry.addr, URL: jquery}) {
+		t.Fatalf("updated: %v", events)
+	}
+
+	// slot of the bucket of the entry
+	table := cache.indexTable()
+	slot := int(entry.Hash & uint32(len(table)-1))
+	head := make([]byte, 4)
+	binary.LittleEndian.PutUint32(head, uint32(table[slot]))
+	offset := int64(indexHeaderSize + 4*slot)
+
+	writeAt(t, dir, "index", offset, make([]byte, 4))
+	events, err = cache.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if types := eventTypes(events, jquery); !slices.Equal(types, []EventType{EntryRemoved}) {
+		t.Fatalf("removed: %v", events)
+	}
+	if _, err = cache.GetAddr(jquery); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("removed: %v, want: %v", err, ErrNotFound)
+	}
+	if n := len(cache.URLs()); n != count-len(events) {
+		t.Fatalf("removed: %d urls, want: %d", n, count-len(events))
+	}
+
+	writeAt(t, dir, "index", offset, head)
+	events, err = cache.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if types := eventTypes(events, jquery); !slices.Equal(types, []EventType{EntryAdded}) {
+		t.Fatalf("added: %v", events)
+	}
+	if n := len(cache.URLs()); n != count {
+		t.Fatalf("added: %d urls, want: %d", n, count)
+	}
+}
+
+func TestReloadBuckets(t *testing.T) {
+	dir := copyCache(t)
+	cache, err := OpenCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	jquery := "https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js"
+	entry, err := cache.OpenURL(jquery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := cache.indexTable()
+	slot := int(entry.Hash & uint32(len(table)-1))
+	cache.mu.RLock()
+	old := cache.buckets
+	cache.mu.RUnlock()
+
+	// data_1 changes, the index does not
+	reuse := binary.LittleEndian.AppendUint32(nil, uint32(entry.ReuseCount+1))
+	writeAt(t, dir, entry.addr.fileName(), entry.addr.blockOffset()+12, reuse)
+
+	events, err := cache.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].URL != jquery {
+		t.Fatalf("updated: %v", events)
+	}
+	cache.mu.RLock()
+	buckets := cache.buckets
+	cache.mu.RUnlock()
+
+	kept := 0
+	for i := range buckets {
+		if len(old[i].entries) == 0 {
+			continue
+		}
+		same := &buckets[i].entries[0] == &old[i].entries[0]
+		if same == (i == slot) {
+			t.Fatalf("bucket %d: kept %v", i, same)
+		}
+		if same {
+			kept++
+		}
+	}
+	if kept == 0 {
+		t.Fatal("no bucket kept")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := copyCache(t)
+	cache, err := OpenCacheWithOptions(dir, &Options{Lazy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	entry, err := cache.OpenURL("https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := cache.Watch(ctx, 10*time.Millisecond)
+
+	// the first reload loads the lazy cache
+	_, err = cache.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reuse := make([]byte, 4)
+	binary.LittleEndian.PutUint32(reuse, uint32(entry.ReuseCount+1))
+	writeAt(t, dir, entry.addr.fileName(), entry.addr.blockOffset()+12, reuse)
+
+	select {
+	case event := <-events:
+		if event.Type != EntryUpdated || event.Addr != entry.addr {
+			t.Fatalf("bad event: %v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+
+	cancel()
+	for range events {
+	}
+}
@@ -0,0 +1,185 @@
+package cdc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"iter"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxTextSize is the maximum size of a decoded body searched for text.
+const maxTextSize = 32 << 20
+
+// Query selects entries of the cache.
+// The zero Query matches all the entries.
+type Query struct {
+	URL       string         // The URL contains URL.
+	URLRegexp *regexp.Regexp // The URL matches URLRegexp.
+
+	// The host of the URL is Host or one of its subdomains,
+	// ignoring case, if not empty.
+	Host string
+
+	// Each value of Header is contained in one of the values of
+	// the same header field, ignoring case.
+	// Like "Content-Type: image/" or "Server: nginx".
+	Header http.Header
+
+	Status int // The response status code, if not zero.
+
+	MinSize int64 // The body size is at least MinSize.
+	MaxSize int64 // The body size is at most MaxSize, if not zero.
+
+	Since time.Time // The entry was created at or after Since, if not zero.
+	Until time.Time // The entry was created before Until, if not zero.
+
+	// The decoded body contains Text, ignoring case.
+	// Only textual bodies are searched.
+	Text string
+}
+
+// Match reports whether the entry matches the query.
+// The response info and the body are only read when needed.
+func (q *Query) Match(e *Entry) (bool, error) {
+	url := e.URL()
+	if q.URL != "" && !strings.Contains(url, q.URL) {
+		return false, nil
+	}
+	if q.URLRegexp != nil && !q.URLRegexp.MatchString(url) {
+		return false, nil
+	}
+	if q.Host != "" && !matchHost(url, q.Host) {
+		return false, nil
+	}
+
+	size := int64(e.DataSize[1])
+	if size < q.MinSize || (q.MaxSize != 0 && size > q.MaxSize) {
+		return false, nil
+	}
+
+	created := e.Created()
+	if !q.Since.IsZero() && created.Before(q.Since) {
+		return false, nil
+	}
+	if !q.Until.IsZero() && !created.Before(q.Until) {
+		return false, nil
+	}
+
+	if len(q.Header) == 0 && q.Status == 0 && q.Text == "" {
+		return true, nil
+	}
+
+	info, err := e.ResponseInfo()
+	if err != nil {
+		return false, err
+	}
+	if q.Status != 0 && info.StatusCode != q.Status {
+		return false, nil
+	}
+	for key, values := range q.Header {
+		for _, value := range values {
+			if !containsValue(info.Header.Values(key), value) {
+				return false, nil
+			}
+		}
+	}
+
+	if q.Text == "" {
+		return true, nil
+	}
+	if !isText(info.Header.Get("Content-Type")) {
+		return false, nil
+	}
+	return e.containsText(q.Text)
+}
+
+// matchHost reports whether the host of rawURL is host or one of
+// its subdomains, ignoring case.
+func matchHost(rawURL, host string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	name, host := strings.ToLower(u.Hostname()), strings.ToLower(host)
+	return name == host || strings.HasSuffix(name, "."+host)
+}
+
+// containsValue reports whether one of values contains value, ignoring case.
+func containsValue(values []string, value string) bool {
+	value = strings.ToLower(value)
+	for _, v := range values {
+		if strings.Contains(strings.ToLower(v), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// isText reports whether contentType is a textual media type.
+func isText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		strings.HasSuffix(mediaType, "+json") {
+		return true
+	}
+	switch mediaType {
+	case "application/javascript", "application/x-javascript",
+		"application/ecmascript", "application/json", "application/xml",
+		"application/xhtml+xml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// containsText reports whether the decoded body contains text, ignoring case.
+func (e *Entry) containsText(text string) (bool, error) {
+	body, err := e.DecodedBody()
+	if errors.Is(err, ErrUnsupportedEncoding) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(body, maxTextSize))
+	if err != nil {
+		return false, &EntryError{Op: "body", Addr: e.addr, Err: err}
+	}
+	return bytes.Contains(bytes.ToLower(b), bytes.ToLower([]byte(text))), nil
+}
+
+// Search returns an iterator over the entries matching the query,
+// in index order. Errors are yielded as Entries does, along with
+// the errors of Query.Match.
+func (c *Cache) Search(q *Query) iter.Seq2[*Entry, error] {
+	return func(yield func(*Entry, error) bool) {
+		for entry, err := range c.Entries() {
+			if err == nil {
+				var ok bool
+				if ok, err = q.Match(entry); err == nil && !ok {
+					continue
+				}
+			}
+			if err != nil {
+				if !yield(nil, err) {
+					return
+				}
+				continue
+			}
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package cdc
+
+// The simple cache format stores each entry in its own files:
+// https://chromium.googlesource.com/chromium/src/net/+/master/disk_cache/simple/simple_entry_format.h
+// https://www.chromium.org/developers/design-documents/network-stack/disk-cache/very-simple-backend
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"iter"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Simple entry files
+const simpleInitialMagic uint64 = 0xfcfb6d1ba7725c30
+const simpleFinalMagic uint64 = 0xf4fa6f45970d41d8
+const simpleSparseMagic uint64 = 0xeb97bf016553676b
+const simpleEntryVersion uint32 = 5
+const simpleIndexVersion uint32 = 9
+const simpleHeaderSize int = 24
+const simpleEOFSize int = 24
+const simpleRangeSize int = 32
+
+// SimpleFileEOF flags
+const simpleHasCRC32 uint32 = 1
+const simpleHasKeySHA256 uint32 = 2
+
+// simpleFileHeader starts the files of an entry, followed by the key.
+type simpleFileHeader struct {
+	Magic     uint64
+	Version   uint32
+	KeyLength uint32
+	KeyHash   uint32 // superFastHash of the key.
+	Pad       uint32
+}
+
+// simpleFileEOF follows the data of a stream.
+type simpleFileEOF struct {
+	Magic      uint64
+	Flags      uint32
+	DataCRC32  uint32
+	StreamSize int32 // Only used for stream 0.
+	Pad        uint32
+}
+
+// simpleSparseRange precedes each range of the sparse file of an entry.
+type simpleSparseRange struct {
+	Magic     uint64
+	Offset    int64
+	Length    int64
+	DataCRC32 uint32
+	Pad       uint32
+}
+
+// simpleFakeIndex is the content of the "index" file,
+// the index itself is stored in "index-dir".
+type simpleFakeIndex struct {
+	Magic   uint64
+	Version uint32
+	Zero    uint32
+	Zero2   uint32
+	Pad     uint32
+}
+
+// simpleEntryHash returns the hash naming the files of the entry key:
+// the first 8 bytes of the SHA-1 of the key, in little endian.
+func simpleEntryHash(key string) uint64 {
+	sum := sha1.Sum([]byte(key))
+	return binary.LittleEndian.Uint64(sum[:8])
+}
+
+// simpleFileName returns the name of the file index of the entry hash,
+// 0 for the streams 0 and 1, 1 for the stream 2, and -1 for the sparse data.
+func simpleFileName(hash uint64, index int) string {
+	if index < 0 {
+		return fmt.Sprintf("%016x_s", hash)
+	}
+	return fmt.Sprintf("%016x_%d", hash, index)
+}
+
+// simpleWriter writes entries into a simple cache. The index of the
+// cache is not written, it is rebuilt from the entry files by Chromium.
+type simpleWriter struct {
+	dir string
+}
+
+// newSimpleWriter returns a writer of the simple cache of dir,
+// creating the cache if dir does not hold one.
+func newSimpleWriter(dir string) (*simpleWriter, error) {
+	err := os.MkdirAll(path.Join(dir, "index-dir"), 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("new writer: %w", err)
+	}
+
+	index := path.Join(dir, "index")
+	if _, err := os.Stat(index); errors.Is(err, fs.ErrNotExist) {
+		b, err := binary.Append(nil, binary.LittleEndian, &simpleFakeIndex{
+			Magic:   simpleInitialMagic,
+			Version: simpleIndexVersion,
+		})
+		if err == nil {
+			err = os.WriteFile(index, b, 0o600)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("new writer: %w", err)
+		}
+	}
+	return &simpleWriter{dir: dir}, nil
+}
+
+// Write writes the files of the entry, its last used time
+// as their modification time.
+func (w *simpleWriter) Write(data *EntryData) (bool, error) {
+	if len(data.Streams[3]) != 0 {
+		return false, fmt.Errorf("write %s: stream 3 not supported by the simple format", data.Key)
+	}
+
+	hash := simpleEntryHash(data.Key)
+	if old, err := readSimpleEntry(w.dir, hash); err == nil {
+		if old.Key != data.Key {
+			return false, fmt.Errorf("write %s: hash collision with %s", data.Key, old.Key)
+		}
+		ok, err := newer(data, old.Streams[0])
+		if err != nil {
+			return false, fmt.Errorf("write %s: %w", data.Key, err)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+
+	files := map[int][]byte{0: simpleStreams(data)}
+	if len(data.Streams[2]) != 0 {
+		b := simpleHeader(data.Key)
+		b = append(b, data.Streams[2]...)
+		files[1] = appendSimpleEOF(b, data.Streams[2], 0)
+	}
+	if len(data.Sparse) != 0 {
+		files[-1] = simpleSparse(data)
+	}
+
+	for _, index := range []int{0, 1, -1} {
+		name := path.Join(w.dir, simpleFileName(hash, index))
+		b, ok := files[index]
+		if !ok {
+			err := os.Remove(name)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return false, fmt.Errorf("write %s: %w", data.Key, err)
+			}
+			continue
+		}
+
+		err := os.WriteFile(name, b, 0o600)
+		if err == nil && !data.LastUsed.IsZero() {
+			err = os.Chtimes(name, data.LastUsed, data.LastUsed)
+		}
+		if err != nil {
+			return false, fmt.Errorf("write %s: %w", data.Key, err)
+		}
+	}
+	return true, nil
+}
+
+// Close does nothing, the entries are complete once written.
+func (w *simpleWriter) Close() error {
+	return nil
+}
+
+// simpleHeader returns the header of the files of the entry key.
+func simpleHeader(key string) []byte {
+	b, _ := binary.Append(nil, binary.LittleEndian, &simpleFileHeader{
+		Magic:     simpleInitialMagic,
+		Version:   simpleEntryVersion,
+		KeyLength: uint32(len(key)),
+		KeyHash:   superFastHash([]byte(key)),
+	})
+	return append(b, key...)
+}
+
+// appendSimpleEOF appends to b the EOF record of the stream data.
+func appendSimpleEOF(b, data []byte, flags uint32) []byte {
+	b, _ = binary.Append(b, binary.LittleEndian, &simpleFileEOF{
+		Magic:      simpleFinalMagic,
+		Flags:      flags | simpleHasCRC32,
+		DataCRC32:  crc32.ChecksumIEEE(data),
+		StreamSize: int32(len(data)),
+	})
+	return b
+}
+
+// simpleStreams returns the file 0 of the entry: the header, stream 1,
+// and stream 0 followed by the SHA-256 of the key.
+func simpleStreams(data *EntryData) []byte {
+	b := simpleHeader(data.Key)
+	b = append(b, data.Streams[1]...)
+	b = appendSimpleEOF(b, data.Streams[1], 0)
+	b = append(b, data.Streams[0]...)
+	sum := sha256.Sum256([]byte(data.Key))
+	b = append(b, sum[:]...)
+	return appendSimpleEOF(b, data.Streams[0], simpleHasKeySHA256)
+}
+
+// simpleSparse returns the sparse file of the entry: the header,
+// and each range preceded by its own header.
+func simpleSparse(data *EntryData) []byte {
+	b := simpleHeader(data.Key)
+	for _, r := range data.Sparse {
+		b, _ = binary.Append(b, binary.LittleEndian, &simpleSparseRange{
+			Magic:     simpleSparseMagic,
+			Offset:    r.Offset,
+			Length:    int64(len(r.Data)),
+			DataCRC32: crc32.ChecksumIEEE(r.Data),
+		})
+		b = append(b, r.Data...)
+	}
+	return b
+}
+
+// simpleEntries returns an iterator over the entries of the simple cache
+// in dir, by their file 0, in hash order.
+func simpleEntries(dir string) iter.Seq2[*EntryData, error] {
+	return func(yield func(*EntryData, error) bool) {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			yield(nil, fmt.Errorf("read entries: %w", err))
+			return
+		}
+		for _, file := range files {
+			name, ok := strings.CutSuffix(file.Name(), "_0")
+			if !ok || len(name) != 16 {
+				continue
+			}
+			hash, err := strconv.ParseUint(name, 16, 64)
+			if err != nil {
+				continue
+			}
+			if !yield(readSimpleEntry(dir, hash)) {
+				return
+			}
+		}
+	}
+}
+
+// readSimpleEntry reads the files of the entry hash in dir.
+// The modification time of file 0 is the last used time of the entry.
+func readSimpleEntry(dir string, hash uint64) (*EntryData, error) {
+	name := path.Join(dir, simpleFileName(hash, 0))
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	key, body, err := parseSimpleHeader(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	data := EntryData{Key: key, LastUsed: info.ModTime(), LastModified: info.ModTime()}
+
+	// stream 0 and its EOF at the end, stream 1 and its EOF before
+	stream0, rest, err := parseSimpleStream(body, true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: stream 0: %w", name, err)
+	}
+	stream1, rest, err := parseSimpleStream(rest, false)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("%s: stream 1: %w", name, ErrMalformed)
+	}
+	data.Streams[0], data.Streams[1] = stream0, stream1
+
+	b, err = os.ReadFile(path.Join(dir, simpleFileName(hash, 1)))
+	switch {
+	case err == nil:
+		_, body, err := parseSimpleHeader(b)
+		if err == nil {
+			data.Streams[2], body, err = parseSimpleStream(body, false)
+		}
+		if err == nil && len(body) != 0 {
+			err = ErrMalformed
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: stream 2: %w", name, err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	b, err = os.ReadFile(path.Join(dir, simpleFileName(hash, -1)))
+	switch {
+	case err == nil:
+		data.Sparse, err = parseSimpleSparse(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: sparse: %w", name, err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+	return &data, nil
+}
+
+// parseSimpleHeader parses the header of an entry file,
+// and returns the key and the data which follows.
+func parseSimpleHeader(b []byte) (string, []byte, error) {
+	var header simpleFileHeader
+	err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &header)
+	if err != nil {
+		return "", nil, fmt.Errorf("read header: %w", ErrMalformed)
+	}
+	if header.Magic != simpleInitialMagic {
+		return "", nil, fmt.Errorf("magic: %x, want: %x: %w",
+			header.Magic, simpleInitialMagic, ErrMalformed)
+	}
+	end := int64(simpleHeaderSize) + int64(header.KeyLength)
+	if end > int64(len(b)) {
+		return "", nil, fmt.Errorf("key length %d out of range: %w",
+			header.KeyLength, ErrMalformed)
+	}
+	key := b[simpleHeaderSize:end]
+	if superFastHash(key) != header.KeyHash {
+		return "", nil, fmt.Errorf("key hash: %w", ErrMalformed)
+	}
+	return string(key), b[end:], nil
+}
+
+// parseSimpleStream parses the stream ending b with its EOF record, and
+// returns its data and the data before. The size of the stream is read
+// from the record if sized, it spans the whole data otherwise.
+func parseSimpleStream(b []byte, sized bool) ([]byte, []byte, error) {
+	if len(b) < simpleEOFSize {
+		return nil, nil, fmt.Errorf("read EOF: %w", ErrMalformed)
+	}
+	var eof simpleFileEOF
+	end := len(b) - simpleEOFSize
+	_ = binary.Read(bytes.NewReader(b[end:]), binary.LittleEndian, &eof)
+	if eof.Magic != simpleFinalMagic {
+		return nil, nil, fmt.Errorf("EOF magic: %x, want: %x: %w",
+			eof.Magic, simpleFinalMagic, ErrMalformed)
+	}
+
+	if eof.Flags&simpleHasKeySHA256 != 0 {
+		end -= sha256.Size
+	}
+	start := 0
+	if sized {
+		start = end - int(eof.StreamSize)
+	}
+	if end < 0 || start < 0 || start > end {
+		return nil, nil, fmt.Errorf("stream size %d out of range: %w",
+			eof.StreamSize, ErrMalformed)
+	}
+
+	data := b[start:end]
+	if eof.Flags&simpleHasCRC32 != 0 && crc32.ChecksumIEEE(data) != eof.DataCRC32 {
+		return nil, nil, fmt.Errorf("stream crc32: %w", ErrMalformed)
+	}
+	return data, b[:start], nil
+}
+
+// parseSimpleSparse parses the ranges of the sparse file b.
+func parseSimpleSparse(b []byte) ([]SparseRange, error) {
+	_, b, err := parseSimpleHeader(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []SparseRange
+	for len(b) != 0 {
+		var header simpleSparseRange
+		err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &header)
+		if err != nil || header.Magic != simpleSparseMagic {
+			return nil, fmt.Errorf("read range: %w", ErrMalformed)
+		}
+		b = b[simpleRangeSize:]
+		if header.Length < 0 || header.Length > int64(len(b)) {
+			return nil, fmt.Errorf("range length %d out of range: %w",
+				header.Length, ErrMalformed)
+		}
+		data := b[:header.Length]
+		if crc32.ChecksumIEEE(data) != header.DataCRC32 {
+			return nil, fmt.Errorf("range crc32: %w", ErrMalformed)
+		}
+		ranges = append(ranges, SparseRange{Offset: header.Offset, Data: data})
+		b = b[header.Length:]
+	}
+	return ranges, nil
+}
@@ -0,0 +1,233 @@
+package cdc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"time"
+)
+
+// Offsets of the self hashes, which hash the bytes before them.
+const (
+	entrySelfHashOffset    = 92
+	rankingsSelfHashOffset = 32
+)
+
+// snapshotAttempts is the number of times the files are copied, or an
+// inconsistent entry is read, before giving up.
+const snapshotAttempts = 3
+
+// snapshotDelay is the delay before the first retry, doubled on each retry.
+const snapshotDelay = 50 * time.Millisecond
+
+// snapshot copies the index and the block files of dir into a new
+// temporary directory. The files are copied again while they change
+// during the copy.
+func snapshot(dir string, log *slog.Logger) (string, error) {
+	tmp, err := os.MkdirTemp("", "cdc-snapshot-")
+	if err != nil {
+		return "", fmt.Errorf("snapshot: %w", err)
+	}
+
+	delay := snapshotDelay
+	for attempt := 1; ; attempt++ {
+		before, err := statFiles(dir)
+		if err == nil {
+			err = copyFiles(dir, tmp, before)
+		}
+		var after []fileStamp
+		if err == nil {
+			after, err = statFiles(dir)
+		}
+		if err != nil {
+			_ = os.RemoveAll(tmp)
+			return "", fmt.Errorf("snapshot: %w", err)
+		}
+
+		if slices.Equal(before, after) {
+			return tmp, nil
+		}
+		if attempt == snapshotAttempts {
+			// the entries are checked as they are read
+			log.Warn("snapshot: files changed while copied", "dir", dir)
+			return tmp, nil
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// copyFiles copies the files of stamps from src to dst, the index first,
+// so the entries added during the copy are not linked from the table.
+// On Linux, io.Copy uses copy_file_range, which clones the data on the
+// file systems supporting it.
+func copyFiles(src, dst string, stamps []fileStamp) error {
+	names := []string{"index"}
+	for _, stamp := range stamps {
+		if stamp.name != "index" {
+			names = append(names, stamp.name)
+		}
+	}
+
+	for _, name := range names {
+		err := copyFile(path.Join(src, name), path.Join(dst, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if e := out.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// openEntry returns the entry at addr. In snapshot mode, an inconsistent
+// entry is copied again from the cache directory, until it is consistent.
+func (c *Cache) openEntry(addr Addr) (*Entry, error) {
+	entry, err := openEntry(addr, c.dir, c.files)
+	if err != nil || !c.opts.Snapshot {
+		return entry, err
+	}
+
+	delay := snapshotDelay
+	for attempt := 1; ; attempt++ {
+		err = entry.checkConsistency(c.thisID.Load())
+		if err == nil {
+			return entry, nil
+		}
+		if attempt == snapshotAttempts {
+			return nil, err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+		err = c.refreshEntry(addr)
+		if err != nil {
+			return nil, err
+		}
+		entry, err = openEntry(addr, c.dir, c.files)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// checkConsistency checks the self hashes of the entry and of its
+// rankings node, and that the node is not dirty. A node whose dirty flag
+// is thisID, the id of the current session, is open in the browser, and
+// consistent; another non-zero flag is left by a session which crashed.
+// The returned error is of type *EntryError and wraps ErrInconsistent.
+func (e *Entry) checkConsistency(thisID int32) error {
+	inconsistent := func(reason string) error {
+		err := fmt.Errorf("%s: %w", reason, ErrInconsistent)
+		return &EntryError{Op: "open", Addr: e.addr, Err: err}
+	}
+
+	b, err := binary.Append(nil, binary.LittleEndian, e.entryStore)
+	if err != nil {
+		return &EntryError{Op: "open", Addr: e.addr, Err: err}
+	}
+	if e.SelfHash != 0 && e.SelfHash != superFastHash(b[:entrySelfHashOffset]) {
+		return inconsistent("entry self hash")
+	}
+
+	node, err := e.rankingsNode()
+	if err != nil {
+		var entryErr *EntryError
+		if errors.As(err, &entryErr) {
+			err = entryErr.Err
+		}
+		return inconsistent(fmt.Sprintf("rankings node: %v", err))
+	}
+	b, err = binary.Append(nil, binary.LittleEndian, node)
+	if err != nil {
+		return &EntryError{Op: "open", Addr: e.addr, Err: err}
+	}
+	if node.SelfHash != 0 && node.SelfHash != superFastHash(b[:rankingsSelfHashOffset]) {
+		return inconsistent("rankings self hash")
+	}
+	if node.Dirty != 0 && node.Dirty != thisID {
+		return inconsistent("rankings node dirty")
+	}
+	return nil
+}
+
+// refreshEntry copies the block of the entry at addr, of its rankings node
+// and of its streams stored in block files, from the cache directory
+// into the snapshot.
+func (c *Cache) refreshEntry(addr Addr) error {
+	src := dirReader(c.dir)
+	entry, err := openEntry(addr, c.dir, src)
+	if err != nil {
+		return err
+	}
+
+	addrs := []Addr{addr, entry.RankingsNode, entry.LongKey}
+	addrs = append(addrs, entry.DataAddr[:]...)
+	for _, a := range addrs {
+		if !a.initialized() || a.separateFile() {
+			continue
+		}
+		if !a.sanityCheck() {
+			return &EntryError{Op: "open", Addr: addr, Err: ErrInvalidAddr}
+		}
+		b, err := readAddr(a, src)
+		if err == nil {
+			err = writeAddr(c.blocks, a, b)
+		}
+		if err != nil {
+			return &EntryError{Op: "open", Addr: addr, Err: err}
+		}
+	}
+	return nil
+}
+
+// writeAddr writes b at addr, in the block file of dir.
+func writeAddr(dir string, addr Addr, b []byte) error {
+	file, err := os.OpenFile(path.Join(dir, addr.fileName()), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(b, addr.blockOffset())
+	if e := file.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// Inconsistent returns the errors of the entries which could not be read
+// consistently in snapshot mode, when the cache was loaded or reloaded.
+// These entries are skipped.
+func (c *Cache) Inconsistent() []error {
+	c.load()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var errs []error
+	for _, b := range c.buckets {
+		if errors.Is(b.err, ErrInconsistent) {
+			errs = append(errs, b.err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,127 @@
+package cdc
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir := copyCache(t)
+	cache, err := OpenCacheWithOptions(dir, &Options{Snapshot: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.blocks == dir {
+		t.Fatal("no snapshot")
+	}
+	if errs := cache.Inconsistent(); len(errs) != 0 {
+		t.Fatalf("inconsistent: %v", errs)
+	}
+	if n := len(cache.URLs()); n != 19 {
+		t.Fatalf("got: %d urls, want: 19", n)
+	}
+
+	jquery := "https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js"
+	addr, err := cache.GetAddr(jquery)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// torn in the snapshot, read again from the cache
+	err = writeAddr(cache.blocks, addr, make([]byte, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := cache.OpenEntry(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.URL() != jquery {
+		t.Fatalf("got: %q, want: %q", entry.URL(), jquery)
+	}
+
+	err = cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(cache.blocks); !os.IsNotExist(err) {
+		t.Fatalf("snapshot not removed: %v", err)
+	}
+}
+
+func TestSnapshotInconsistent(t *testing.T) {
+	dir := copyCache(t)
+	cache, err := OpenCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jquery := "https://ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js"
+	entry, err := cache.OpenURL(jquery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = cache.Close()
+
+	index, _, err := readIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// mark the rankings node of the entry dirty, by another session
+	setDirty(t, dir, entry.RankingsNode, index.ThisID+1)
+
+	cache, err = OpenCacheWithOptions(dir, &Options{Snapshot: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	errs := cache.Inconsistent()
+	if len(errs) != 1 || !errors.Is(errs[0], ErrInconsistent) {
+		t.Fatalf("inconsistent: %v", errs)
+	}
+	var entryErr *EntryError
+	if !errors.As(errs[0], &entryErr) || entryErr.Addr != entry.addr {
+		t.Fatalf("bad error: %v", errs[0])
+	}
+	if _, err = cache.GetAddr(jquery); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got: %v, want: %v", err, ErrNotFound)
+	}
+	if _, err = cache.OpenEntry(entry.addr); !errors.Is(err, ErrInconsistent) {
+		t.Fatalf("got: %v, want: %v", err, ErrInconsistent)
+	}
+	_ = cache.Close()
+
+	// open in the current session
+	setDirty(t, dir, entry.RankingsNode, index.ThisID)
+
+	cache, err = OpenCacheWithOptions(dir, &Options{Snapshot: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if errs := cache.Inconsistent(); len(errs) != 0 {
+		t.Fatalf("inconsistent: %v", errs)
+	}
+	if _, err = cache.OpenURL(jquery); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setDirty sets the dirty flag of the rankings node at addr,
+// and its self hash.
+func setDirty(t *testing.T, dir string, addr Addr, dirty int32) {
+	t.Helper()
+	b, err := os.ReadFile(path.Join(dir, addr.fileName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := b[addr.blockOffset():][:rankingsSelfHashOffset]
+	binary.LittleEndian.PutUint32(node[28:], uint32(dirty))
+	hash := binary.LittleEndian.AppendUint32(nil, superFastHash(node))
+	writeAt(t, dir, addr.fileName(), addr.blockOffset()+28, node[28:])
+	writeAt(t, dir, addr.fileName(), addr.blockOffset()+rankingsSelfHashOffset, hash)
+}
@@ -0,0 +1,64 @@
+package cdc
+
+import (
+	"net/http"
+	"time"
+)
+
+// Sources of the timestamps of an entry.
+const (
+	SourceCreated            = "created"             // Creation of the entry.
+	SourceLastUsed           = "lastUsed"            // LRU info of the rankings node.
+	SourceLastModified       = "lastModified"        // LRU info of the rankings node.
+	SourceRequestTime        = "requestTime"         // Response info.
+	SourceResponseTime       = "responseTime"        // Response info.
+	SourceHeaderDate         = "header.date"         // Date header.
+	SourceHeaderLastModified = "header.lastModified" // Last-Modified header.
+	SourceHeaderExpires      = "header.expires"      // Expires header.
+)
+
+// Timestamp is a time of an entry, read from one source.
+type Timestamp struct {
+	Source string
+	Time   time.Time
+}
+
+// Timestamps returns the times of the entry, in the order of the sources.
+// The zero times, the LRU info which can not be read and the headers
+// which can not be parsed are ignored. The children of sparse entries
+// have no response info.
+// The returned error is of type *EntryError.
+func (e *Entry) Timestamps() ([]Timestamp, error) {
+	var ts []Timestamp
+	add := func(source string, t time.Time) {
+		if !t.IsZero() {
+			ts = append(ts, Timestamp{Source: source, Time: t})
+		}
+	}
+
+	add(SourceCreated, e.Created())
+	if rankings, err := e.Rankings(); err == nil {
+		add(SourceLastUsed, rankings.LastUsed)
+		add(SourceLastModified, rankings.LastModified)
+	}
+	if e.Child() {
+		return ts, nil
+	}
+
+	info, err := e.ResponseInfo()
+	if err != nil {
+		return nil, err
+	}
+	add(SourceRequestTime, info.RequestTime)
+	add(SourceResponseTime, info.ResponseTime)
+	for _, h := range []struct{ source, name string }{
+		{SourceHeaderDate, "Date"},
+		{SourceHeaderLastModified, "Last-Modified"},
+		{SourceHeaderExpires, "Expires"},
+	} {
+		if t, err := http.ParseTime(info.Header.Get(h.name)); err == nil {
+			add(h.source, t)
+		}
+	}
+	return ts, nil
+}
@@ -0,0 +1,49 @@
+package cdc
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestTimestamps(t *testing.T) {
+	cache, err := OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	entry, err := cache.OpenURL("https://golang.org/lib/godoc/style.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := entry.Timestamps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources := make([]string, len(ts))
+	for i, s := range ts {
+		sources[i] = s.Source
+	}
+	want := []string{SourceCreated, SourceLastUsed, SourceLastModified,
+		SourceRequestTime, SourceResponseTime, SourceHeaderDate}
+	if len(sources) < len(want) || !slices.Equal(sources[:len(want)], want) {
+		t.Fatalf("sources: %q, want: %q first", sources, want)
+	}
+
+	if !ts[0].Time.Equal(entry.Created()) {
+		t.Errorf("created: %v, want: %v", ts[0].Time, entry.Created())
+	}
+	info, err := entry.ResponseInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	date, err := http.ParseTime(info.Header.Get("Date"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ts[5].Time.Equal(date) {
+		t.Errorf("date: %v, want: %v", ts[5].Time, date)
+	}
+}
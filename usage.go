@@ -0,0 +1,242 @@
+package cdc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Usage is the disk usage of the entries of a cache.
+type Usage struct {
+	Total  UsageStat `json:"total"`  // All the entries, including the children of sparse entries.
+	Bytes  int64     `json:"bytes"`  // Size of all the streams of the entries.
+	Errors int       `json:"errors"` // Chains of entries which could not be read, or matched.
+
+	// The counters of the index header.
+	IndexEntries int32 `json:"indexEntries"`
+	IndexBytes   int32 `json:"indexBytes"`
+
+	// Usage of the entries by host of their URL, by MIME type of their
+	// response, and by age of their creation.
+	ByHost map[string]*UsageStat `json:"byHost"`
+	ByMIME map[string]*UsageStat `json:"byMIME"`
+	ByAge  []AgeStat             `json:"byAge"`
+
+	// Usage of the streams by type of file storing them: "block_256",
+	// "block_1k", "block_4k" or "external" for the separate files.
+	// Count is a number of streams.
+	ByFileType map[string]*UsageStat `json:"byFileType"`
+
+	BlockFiles []BlockFileUsage `json:"blockFiles"`
+}
+
+// UsageStat counts entries and the size of their header and body.
+type UsageStat struct {
+	Count       int   `json:"count"`
+	HeaderBytes int64 `json:"headerBytes"` // Size of the response info, stream 0.
+	BodyBytes   int64 `json:"bodyBytes"`   // Size of the body, stream 1.
+}
+
+func (s *UsageStat) add(header, body int64) {
+	s.Count++
+	s.HeaderBytes += header
+	s.BodyBytes += body
+}
+
+// AgeStat is the usage of the entries created less than MaxAge ago,
+// and not counted in a previous AgeStat. The last MaxAge is zero.
+type AgeStat struct {
+	Label  string        `json:"label"` // Like "<1d" or ">1y".
+	MaxAge time.Duration `json:"maxAge"`
+	UsageStat
+}
+
+// ageBuckets are the ages of the AgeStats of Usage.
+var ageBuckets = []struct {
+	label  string
+	maxAge time.Duration
+}{
+	{"<1d", 24 * time.Hour},
+	{"<7d", 7 * 24 * time.Hour},
+	{"<30d", 30 * 24 * time.Hour},
+	{"<1y", 365 * 24 * time.Hour},
+	{">1y", 0},
+}
+
+// BlockFileUsage is the usage of the blocks of a block file,
+// read from its header.
+type BlockFileUsage struct {
+	Name       string `json:"name"` // Like "data_1".
+	BlockSize  int32  `json:"blockSize"`
+	MaxBlocks  int32  `json:"maxBlocks"`  // Blocks of the file.
+	UsedBlocks int    `json:"usedBlocks"` // Blocks marked as used by the allocation map.
+	NumEntries int32  `json:"numEntries"` // Allocations, of 1 to 4 blocks.
+
+	// Empty counts the groups of 4 blocks with 1 to 4 free blocks at their
+	// end, where an allocation of as many blocks can start. The counters
+	// of the header are Stale if they differ from the allocation map.
+	Empty [4]int32 `json:"empty"`
+	Stale bool     `json:"stale"`
+
+	// Fragmentation is the ratio of the free blocks which can not hold
+	// an allocation of 4 blocks.
+	Fragmentation float64 `json:"fragmentation"`
+}
+
+// Usage reads all the entries of the cache, and the headers of the index
+// and of the block files, to report the disk usage of the cache.
+func (c *Cache) Usage() (*Usage, error) {
+	return c.UsageQuery(nil)
+}
+
+// UsageQuery is like Usage, counting only the entries matching query.
+// A nil query matches all the entries. The counters of the index and the
+// usage of the block files are those of the whole cache.
+func (c *Cache) UsageQuery(query *Query) (*Usage, error) {
+	now := time.Now()
+	index, _, err := readIndex(c.blocks)
+	if err != nil {
+		return nil, fmt.Errorf("usage: %w", err)
+	}
+
+	usage := Usage{
+		IndexEntries: index.NumEntries,
+		IndexBytes:   index.NumBytes,
+		ByHost:       make(map[string]*UsageStat),
+		ByMIME:       make(map[string]*UsageStat),
+		ByFileType:   make(map[string]*UsageStat),
+	}
+	for _, b := range ageBuckets {
+		usage.ByAge = append(usage.ByAge, AgeStat{Label: b.label, MaxAge: b.maxAge})
+	}
+
+	for _, addr := range c.indexTable() {
+		for entry, err := range c.chain(addr) {
+			if err != nil {
+				usage.Errors++
+				break
+			}
+			if entry.State != 0 {
+				continue
+			}
+			if query != nil {
+				ok, err := query.Match(entry)
+				if err != nil {
+					usage.Errors++
+				}
+				if !ok {
+					continue
+				}
+			}
+			usage.addEntry(entry, now)
+		}
+	}
+
+	for number := range 4 {
+		name := fmt.Sprintf("data_%d", number)
+		b, err := readBlockFileUsage(path.Join(c.blocks, name))
+		if err != nil {
+			return nil, fmt.Errorf("usage: %w", err)
+		}
+		usage.BlockFiles = append(usage.BlockFiles, *b)
+	}
+	return &usage, nil
+}
+
+// addEntry adds the usage of the entry.
+func (u *Usage) addEntry(e *Entry, now time.Time) {
+	header, body := int64(e.DataSize[0]), int64(e.DataSize[1])
+	u.Total.add(header, body)
+	for _, size := range e.DataSize {
+		u.Bytes += int64(size)
+	}
+
+	stat := func(m map[string]*UsageStat, key string) *UsageStat {
+		s, ok := m[key]
+		if !ok {
+			s = new(UsageStat)
+			m[key] = s
+		}
+		return s
+	}
+
+	// the children of sparse entries are counted with the host of their parent
+	key := strings.TrimPrefix(e.URL(), "Range_")
+	host := ""
+	if parsed, err := url.Parse(key); err == nil {
+		host = strings.ToLower(parsed.Hostname())
+	}
+	stat(u.ByHost, host).add(header, body)
+
+	mimeType := ""
+	if !e.Child() {
+		if info, err := e.ResponseInfo(); err == nil {
+			mimeType, _, _ = mime.ParseMediaType(info.Header.Get("Content-Type"))
+		}
+	}
+	stat(u.ByMIME, mimeType).add(header, body)
+
+	for i, addr := range e.DataAddr[:2] {
+		if addr.initialized() {
+			s := stat(u.ByFileType, addr.Info().FileType)
+			s.Count++
+			if i == 0 {
+				s.HeaderBytes += header
+			} else {
+				s.BodyBytes += body
+			}
+		}
+	}
+
+	age := now.Sub(e.Created())
+	for i := range u.ByAge {
+		if b := &u.ByAge[i]; b.MaxAge == 0 || age < b.MaxAge {
+			b.add(header, body)
+			break
+		}
+	}
+}
+
+// readBlockFileUsage reads the usage of the block file name.
+func readBlockFileUsage(name string) (*BlockFileUsage, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var header blockFileHeader
+	err = binary.Read(file, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if header.Magic != blockMagic {
+		return nil, fmt.Errorf("%s magic: %x, want: %x: %w",
+			name, header.Magic, blockMagic, ErrMalformed)
+	}
+	blocks := min(max(header.MaxEntries, 0), int32(maxBlocks))
+
+	usage := BlockFileUsage{
+		Name:       path.Base(name),
+		BlockSize:  header.EntrySize,
+		MaxBlocks:  blocks,
+		NumEntries: header.NumEntries,
+		Empty:      header.Empty,
+	}
+	for i := range (blocks + 31) / 32 {
+		usage.UsedBlocks += bits.OnesCount32(header.AllocationMap[i])
+	}
+
+	empty := countEmpty(&header.AllocationMap, blocks)
+	usage.Stale = empty != header.Empty
+	if free := int(blocks) - usage.UsedBlocks; free > 0 {
+		usage.Fragmentation = 1 - float64(4*empty[3])/float64(free)
+	}
+	return &usage, nil
+}
@@ -0,0 +1,80 @@
+package cdc
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestUsage(t *testing.T) {
+	cache, err := OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	usage, err := cache.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usage.Total.Count != 19 || usage.IndexEntries != 19 || usage.Errors != 0 {
+		t.Errorf("entries: %d, index: %d, errors: %d, want: 19, 19, 0",
+			usage.Total.Count, usage.IndexEntries, usage.Errors)
+	}
+	if usage.Bytes < usage.Total.HeaderBytes+usage.Total.BodyBytes {
+		t.Errorf("bytes: %d, less than header and body: %+v", usage.Bytes, usage.Total)
+	}
+	if s := usage.ByHost["golang.org"]; s == nil || s.Count != 17 {
+		t.Errorf("golang.org: %+v, want: 17 entries", s)
+	}
+	if s := usage.ByAge[len(usage.ByAge)-1]; s.Label != ">1y" || s.Count != 19 {
+		t.Errorf("age: %+v, want: 19 entries >1y", s)
+	}
+
+	count := 0
+	for _, s := range usage.ByFileType {
+		count += s.Count
+	}
+	if s := usage.ByFileType["external"]; s == nil || s.BodyBytes == 0 || count < 2*19 {
+		t.Errorf("file types: %d streams, external: %+v", count, s)
+	}
+
+	if len(usage.BlockFiles) != 4 {
+		t.Fatalf("block files: %d, want: 4", len(usage.BlockFiles))
+	}
+	rankings := usage.BlockFiles[0]
+	if rankings.Name != "data_0" || rankings.UsedBlocks != 19 || rankings.Stale ||
+		rankings.Empty != [4]int32{1, 0, 0, 251} {
+		t.Errorf("data_0: %+v", rankings)
+	}
+	if f := usage.BlockFiles[1].Fragmentation; f <= 0 || f >= 1 {
+		t.Errorf("data_1 fragmentation: %f", f)
+	}
+}
+
+func TestUsageQuery(t *testing.T) {
+	cache, err := OpenCache("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	usage, err := cache.UsageQuery(&Query{URLRegexp: regexp.MustCompile(`\.css$`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usage.Total.Count != 2 || usage.IndexEntries != 19 || usage.Errors != 0 {
+		t.Errorf("entries: %d, index: %d, errors: %d, want: 2, 19, 0",
+			usage.Total.Count, usage.IndexEntries, usage.Errors)
+	}
+	if s := usage.ByHost["golang.org"]; s == nil || s.Count != 2 || len(usage.ByHost) != 1 {
+		t.Errorf("hosts: %v, want: 2 entries of golang.org", usage.ByHost)
+	}
+	if s := usage.ByMIME["text/javascript"]; s == nil || s.Count != 2 || len(usage.ByMIME) != 1 {
+		t.Errorf("types: %v, want: 2 entries of text/javascript", usage.ByMIME)
+	}
+	if len(usage.BlockFiles) != 4 || usage.BlockFiles[0].UsedBlocks != 19 {
+		t.Errorf("block files: %+v, want: the whole cache", usage.BlockFiles)
+	}
+}
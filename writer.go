@@ -0,0 +1,131 @@
+package cdc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// ErrUnalignedSparse is returned when writing into a blockfile cache
+// sparse data which can not be stored by blocks of 1 KB.
+var ErrUnalignedSparse = errors.New("sparse data not aligned on 1 KB blocks")
+
+// Format is the on-disk format of a cache.
+type Format int
+
+// The formats of the chromium disk cache.
+const (
+	Blockfile Format = iota // index, data_[0-3] and f_[0-9]+ separate files
+	Simple                  // one file per entry, named by the hash of its key
+)
+
+func (f Format) String() string {
+	switch f {
+	case Blockfile:
+		return "blockfile"
+	case Simple:
+		return "simple"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// ParseFormat returns the format of name, "blockfile" or "simple".
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "blockfile":
+		return Blockfile, nil
+	case "simple":
+		return Simple, nil
+	}
+	return 0, fmt.Errorf("unknown cache format %q", name)
+}
+
+// DetectFormat returns the format of the cache in dir,
+// from the magic number of its "index" file.
+func DetectFormat(dir string) (Format, error) {
+	file, err := os.Open(path.Join(dir, "index"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var magic uint64
+	err = binary.Read(file, binary.LittleEndian, &magic)
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			err = ErrMalformed
+		}
+		return 0, fmt.Errorf("detect format: %w", err)
+	}
+
+	switch {
+	case uint32(magic) == magicNumber:
+		return Blockfile, nil
+	case magic == simpleInitialMagic:
+		return Simple, nil
+	}
+	return 0, fmt.Errorf("detect format: magic %x: %w", magic, ErrMalformed)
+}
+
+// Writer writes entries into a cache.
+//
+// The cache must not be used by a browser or another Writer while it is
+// written. A blockfile cache is consistent once the Writer is closed.
+type Writer interface {
+	// Write writes the entry, unless the cache holds an entry of the same
+	// key with a response at least as recent. It reports whether the
+	// entry was written, replacing the older entry if any.
+	Write(data *EntryData) (bool, error)
+
+	// Close completes the cache and releases its files.
+	Close() error
+}
+
+// NewWriter returns a Writer adding entries to the cache of dir,
+// created in format if dir does not hold a cache.
+// An error is returned if the cache of dir has another format.
+func NewWriter(dir string, format Format) (Writer, error) {
+	existing, err := DetectFormat(dir)
+	if err == nil && existing != format {
+		return nil, fmt.Errorf("new writer: %s holds a %s cache, not %s", dir, existing, format)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("new writer: %w", err)
+	}
+
+	switch format {
+	case Blockfile:
+		return newBlockWriter(dir)
+	case Simple:
+		return newSimpleWriter(dir)
+	}
+	return nil, fmt.Errorf("new writer: unknown format %s", format)
+}
+
+// CheckEntryData returns an error if data can not be written without
+// loss into a cache of format: ErrUnalignedSparse if a blockfile cache
+// can not store its sparse data.
+func CheckEntryData(data *EntryData, format Format) error {
+	if format == Blockfile {
+		return checkSparse(data.Sparse)
+	}
+	return nil
+}
+
+// newer reports whether the response of data is more recent than the
+// response of old, the response info stream of an entry.
+// An unreadable old response is replaced.
+func newer(data *EntryData, old []byte) (bool, error) {
+	info, err := data.ResponseInfo()
+	if err != nil {
+		return false, err
+	}
+	oldInfo, err := parseResponseInfo(old)
+	if err != nil {
+		return true, nil
+	}
+	return info.ResponseTime.After(oldInfo.ResponseTime), nil
+}
@@ -0,0 +1,331 @@
+package cdc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readAll reads the data of all the entries of the cache in dir.
+func readAll(t *testing.T, dir string) map[string]*EntryData {
+	t.Helper()
+	cache, err := OpenCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	all := make(map[string]*EntryData)
+	for entry, err := range cache.Entries() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Child() {
+			continue
+		}
+		data, err := cache.EntryData(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all[data.Key] = data
+	}
+	return all
+}
+
+// writeAll writes entries into the cache of dir, and returns
+// the number of entries written.
+func writeAll(t *testing.T, dir string, format Format, entries ...*EntryData) int {
+	t.Helper()
+	w, err := NewWriter(dir, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, data := range entries {
+		ok, err := w.Write(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			n++
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// equalData reports whether a and b hold the same key, streams,
+// sparse data and last used time.
+func equalData(a, b *EntryData) bool {
+	return a.Key == b.Key && a.LastUsed.Equal(b.LastUsed) &&
+		slices.EqualFunc(a.Streams[:], b.Streams[:], bytes.Equal) &&
+		slices.EqualFunc(a.Sparse, b.Sparse, func(x, y SparseRange) bool {
+			return x.Offset == y.Offset && bytes.Equal(x.Data, y.Data)
+		})
+}
+
+// ranges returns the offset and length of each range.
+func ranges(sparse []SparseRange) [][2]int64 {
+	var r [][2]int64
+	for _, s := range sparse {
+		r = append(r, [2]int64{s.Offset, int64(len(s.Data))})
+	}
+	return r
+}
+
+// withResponseTime returns a copy of data responded at t.
+func withResponseTime(data *EntryData, t time.Time) *EntryData {
+	clone := *data
+	clone.Streams[0] = slices.Clone(data.Streams[0])
+	binary.LittleEndian.PutUint64(clone.Streams[0][16:], chromeTimestamp(t))
+	return &clone
+}
+
+func TestWriteBlockfile(t *testing.T) {
+	src := readAll(t, "testdata")
+	entries := slices.Collect(maps.Values(src))
+
+	dir := path.Join(t.TempDir(), "cache")
+	if n := writeAll(t, dir, Blockfile, entries...); n != len(src) {
+		t.Fatalf("written: %d, want: %d", n, len(src))
+	}
+	dst := readAll(t, dir)
+	if len(dst) != len(src) {
+		t.Fatalf("entries: %d, want: %d", len(dst), len(src))
+	}
+	for key, data := range src {
+		if !equalData(dst[key], data) {
+			t.Errorf("entry %s differs", key)
+		}
+	}
+
+	// same responses are kept, newer ones replace them
+	if n := writeAll(t, dir, Blockfile, entries...); n != 0 {
+		t.Fatalf("written again: %d, want: 0", n)
+	}
+	info, err := entries[0].ResponseInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer := withResponseTime(entries[0], info.ResponseTime.Add(time.Hour))
+	older := withResponseTime(entries[1], time.Unix(0, 0))
+	if n := writeAll(t, dir, Blockfile, newer, older); n != 1 {
+		t.Fatalf("written newer and older: %d, want: 1", n)
+	}
+
+	dst = readAll(t, dir)
+	if len(dst) != len(src) {
+		t.Fatalf("entries: %d, want: %d", len(dst), len(src))
+	}
+	if !equalData(dst[newer.Key], newer) {
+		t.Errorf("entry %s not replaced", newer.Key)
+	}
+	if !equalData(dst[older.Key], entries[1]) {
+		t.Errorf("entry %s replaced", older.Key)
+	}
+
+	index, _, err := readIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lru := index.Lru
+	if int(index.NumEntries) != len(src) || int(lru.Sizes[0]+lru.Sizes[1]+lru.Sizes[2]) != len(src) {
+		t.Errorf("index entries: %d, lists %v, want: %d", index.NumEntries, lru.Sizes, len(src))
+	}
+}
+
+func TestWriteSparse(t *testing.T) {
+	src := readAll(t, "testdata")
+	png := src["https://golang.org/doc/gopher/pkg.png"]
+
+	data := &EntryData{
+		Key:      "https://example.com/video.mp4",
+		Streams:  [4][]byte{0: png.Streams[0]},
+		LastUsed: png.LastUsed,
+		Sparse: []SparseRange{
+			{Offset: 0, Data: bytes.Repeat([]byte("a"), 3000)},
+			{Offset: sparseChildSize - 1024, Data: bytes.Repeat([]byte("b"), 2148)},
+			{Offset: 3 * sparseChildSize, Data: bytes.Repeat([]byte("c"), 700)},
+		},
+	}
+
+	dir := path.Join(t.TempDir(), "cache")
+	writeAll(t, dir, Blockfile, data, png)
+	dst := readAll(t, dir)
+	if len(dst) != 2 {
+		t.Fatalf("entries: %d, want: 2", len(dst))
+	}
+	if !equalData(dst[data.Key], data) {
+		t.Fatalf("sparse ranges: %v, want: %v", ranges(dst[data.Key].Sparse), ranges(data.Sparse))
+	}
+
+	// replaced with its children
+	newer := withResponseTime(data, time.Now())
+	newer.Sparse = newer.Sparse[:1]
+	writeAll(t, dir, Blockfile, newer)
+	dst = readAll(t, dir)
+	if !equalData(dst[data.Key], newer) {
+		t.Fatalf("sparse ranges: %v, want: %v", ranges(dst[data.Key].Sparse), ranges(newer.Sparse))
+	}
+	cache, err := OpenCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if urls := cache.URLs(); len(urls) != 3 {
+		t.Errorf("urls: %q, want the entries and one child", urls)
+	}
+}
+
+func TestCheckSparse(t *testing.T) {
+	data := func(offset, n int64) SparseRange {
+		return SparseRange{Offset: offset, Data: make([]byte, n)}
+	}
+	tests := []struct {
+		sparse []SparseRange
+		ok     bool
+	}{
+		{[]SparseRange{data(0, 3000), data(sparseChildSize-1024, 2148)}, true},
+		{[]SparseRange{data(0, 1024), data(2048, 100)}, true},
+		{[]SparseRange{data(0, 1000), data(2048, 100)}, false}, // two partial blocks
+		{[]SparseRange{data(100, 1024)}, false},                // not on a block
+	}
+	for i, tt := range tests {
+		err := checkSparse(tt.sparse)
+		if (err == nil) != tt.ok || (err != nil && !errors.Is(err, ErrUnalignedSparse)) {
+			t.Errorf("%d: %v, want ok: %v", i, err, tt.ok)
+		}
+	}
+
+	src := readAll(t, "testdata")
+	png := *src["https://golang.org/doc/gopher/pkg.png"]
+	png.Sparse = []SparseRange{data(100, 1024)}
+	w, err := NewWriter(path.Join(t.TempDir(), "cache"), Blockfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if ok, err := w.Write(&png); ok || !errors.Is(err, ErrUnalignedSparse) {
+		t.Fatalf("write: %v, %v, want: %v", ok, err, ErrUnalignedSparse)
+	}
+}
+
+func TestWriteLongKey(t *testing.T) {
+	src := readAll(t, "testdata")
+	png := src["https://golang.org/doc/gopher/pkg.png"]
+
+	// in the blocks of the entry, and at the LongKey address
+	var entries []*EntryData
+	for _, n := range []int{300, 2000} {
+		data := *png
+		data.Key = "https://example.com/?q=" + strings.Repeat("a", n-23)
+		entries = append(entries, &data)
+	}
+
+	dir := path.Join(t.TempDir(), "cache")
+	writeAll(t, dir, Blockfile, entries...)
+	dst := readAll(t, dir)
+	if len(dst) != len(entries) {
+		t.Fatalf("entries: %d, want: %d", len(dst), len(entries))
+	}
+	for _, data := range entries {
+		if !equalData(dst[data.Key], data) {
+			t.Errorf("entry of key length %d differs", len(data.Key))
+		}
+	}
+
+	cache, err := OpenCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range entries {
+		entry, err := cache.OpenURL(data.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if long := entry.LongKey.initialized(); long != (i == 1) {
+			t.Errorf("key length %d: long key %v", len(data.Key), long)
+		}
+	}
+	_ = cache.Close()
+
+	// replaced, with the blocks of the key
+	newer := withResponseTime(entries[1], time.Now())
+	if n := writeAll(t, dir, Blockfile, newer); n != 1 {
+		t.Fatalf("written newer: %d, want: 1", n)
+	}
+	dst = readAll(t, dir)
+	if len(dst) != len(entries) || !equalData(dst[newer.Key], newer) {
+		t.Fatalf("entry of key length %d not replaced", len(newer.Key))
+	}
+}
+
+func TestWriteSimple(t *testing.T) {
+	src := readAll(t, "testdata")
+	png := src["https://golang.org/doc/gopher/pkg.png"]
+	png.Sparse = []SparseRange{{Offset: 5, Data: []byte("sparse")}}
+	entries := slices.Collect(maps.Values(src))
+
+	dir := path.Join(t.TempDir(), "cache")
+	writeAll(t, dir, Simple, entries...)
+	if format, err := DetectFormat(dir); err != nil || format != Simple {
+		t.Fatalf("format: %v, %v, want: simple", format, err)
+	}
+	if _, err := NewWriter(dir, Blockfile); err == nil {
+		t.Fatal("blockfile writer of a simple cache")
+	}
+
+	for key, data := range src {
+		got, err := readSimpleEntry(dir, simpleEntryHash(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalData(got, data) {
+			t.Errorf("entry %s differs", key)
+		}
+	}
+
+	if n := writeAll(t, dir, Simple, entries...); n != 0 {
+		t.Fatalf("written again: %d, want: 0", n)
+	}
+}
+
+func TestReadEntries(t *testing.T) {
+	src := readAll(t, "testdata")
+
+	// blockfile to simple, and back to blockfile
+	dir := "testdata"
+	for _, format := range []Format{Simple, Blockfile} {
+		dst := path.Join(t.TempDir(), format.String())
+		var entries []*EntryData
+		for data, err := range ReadEntries(dir) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, data)
+		}
+		if n := writeAll(t, dst, format, entries...); n != len(src) {
+			t.Fatalf("%s: written: %d, want: %d", format, n, len(src))
+		}
+		dir = dst
+	}
+
+	dst := readAll(t, dir)
+	if len(dst) != len(src) {
+		t.Fatalf("entries: %d, want: %d", len(dst), len(src))
+	}
+	for key, data := range src {
+		if !equalData(dst[key], data) {
+			t.Errorf("entry %s differs", key)
+		}
+	}
+}